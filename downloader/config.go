@@ -0,0 +1,269 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// config.go implements:
+//  - Configuration of images downloading.
+
+package downloader
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"regexp"
+	"runtime"
+	"time"
+)
+
+const (
+	defaultTimeout      = 30 * time.Second
+	defaultMaxAttempts  = 3
+	defaultRetryDelay   = 500 * time.Millisecond
+	defaultMaxRedirects = 10
+	defaultMaxPageBytes = 10 << 20
+)
+
+// Config controls how images are fetched and stored.
+type Config struct {
+	// Timeout limits the time of a single HTTP request, including reading
+	// the body of a parsed page, zero means no limit.
+	Timeout time.Duration
+	// InsecureSkipVerify disables verification of the server TLS certificate.
+	InsecureSkipVerify bool
+	// AllSrcsetCandidates download every `srcset` candidate instead of the
+	// highest resolution one.
+	AllSrcsetCandidates bool
+	// Concurrency is the maximum number of simultaneous downloads, zero means
+	// runtime.GOMAXPROCS(0).
+	Concurrency int
+	// MaxPerHost is the maximum number of simultaneous downloads from a
+	// single host, zero means no per-host limit.
+	MaxPerHost int
+	// SkipExisting do not download again images which already exist in the
+	// output directory, same as OnExisting set to ExistingSkip.
+	SkipExisting bool
+	// OnExisting decides what happens to images whose file already exists
+	// in the output directory.
+	OnExisting ExistingPolicy
+	// FilenameTemplate defines names of downloaded files using {host},
+	// {index}, {basename} and {ext} tokens, e.g. "{host}-{index}.{ext}".
+	// Empty template keeps the name from URL.
+	FilenameTemplate string
+	// InlineFilenameTemplate defines names of images inlined into the page
+	// using {index}, {hash} and {ext} tokens, e.g. "inline-{index}.{ext}".
+	// Empty template gives random names.
+	InlineFilenameTemplate string
+	// PreservePaths save images into subdirectories matching the URL path,
+	// e.g. "assets/img/logo.png" for "https://site/assets/img/logo.png".
+	PreservePaths bool
+	// HashNames name files after SHA-256 of the content, e.g.
+	// "<sha256>.png". Images with the same content are saved once, the
+	// following ones are reported as skipped. Sinks other than DirSink can't
+	// rename files, so the whole image, up to MaxTotalBytes, is held in memory
+	// until it is hashed.
+	HashNames bool
+	// MaxAttempts is the number of times a request is sent when it fails with
+	// network error, "429 Too Many Requests" or server error.
+	MaxAttempts int
+	// RetryDelay is the delay before the first retry, it doubles with every
+	// next attempt.
+	RetryDelay time.Duration
+	// UserAgent overrides the default Go User-Agent header.
+	UserAgent string
+	// Headers are added to every request, e.g. "Referer".
+	Headers map[string]string
+	// Username and Password are sent to AuthHosts using basic
+	// authentication.
+	Username string
+	Password string
+	// BearerToken is sent to AuthHosts in "Authorization: Bearer" header,
+	// unless basic authentication is used.
+	BearerToken string
+	// AuthHosts are hosts, optionally with port, credentials are sent to.
+	// When empty, they are sent to hosts of the requested pages only, not to
+	// image hosts or other sites the pages link to.
+	AuthHosts []string
+	// CookieJar stores cookies of the run, a new in-memory jar is used when
+	// nil.
+	CookieJar http.CookieJar
+	// Cookies are sent to the page host, e.g. a session cookie.
+	Cookies []*http.Cookie
+	// Transport sends requests instead of the default transport, e.g. for
+	// caching or serving canned responses. InsecureSkipVerify, DialContext,
+	// ResolveHosts and BlockPrivateNetworks configure the default transport
+	// and are not applied to it.
+	Transport http.RoundTripper
+	// DialContext opens network connections instead of the default dialer.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// ResolveHosts connects to the given address instead of resolving the
+	// host, e.g. "staging.example.com": "10.0.0.5". Address without port
+	// keeps the port of the URL.
+	ResolveHosts map[string]string
+	// BlockPrivateNetworks refuse to fetch pages and images from loopback,
+	// private and link-local addresses, e.g. when the page is not trusted.
+	// Hosts are checked after DNS resolution, so proxy from the environment is
+	// not used.
+	BlockPrivateNetworks bool
+	// MinBytes discards images smaller than the given size.
+	MinBytes int64
+	// MaxInlineBytes records Data URL of larger inline images in the manifest
+	// instead of saving them, their entries have the manifest as Filename.
+	// The manifest is written as with WriteManifest. Zero saves all inline
+	// images.
+	MaxInlineBytes int64
+	// MinWidth and MinHeight discard images with smaller dimensions. Formats
+	// other than PNG, JPEG and GIF are not checked.
+	MinWidth  int
+	MinHeight int
+	// SkipAnimated discard GIF images with more than one frame. Other
+	// formats are not checked.
+	SkipAnimated bool
+	// Resume continue files left by interrupted downloads using HTTP range
+	// requests, the whole image is downloaded again when the server does not
+	// support them or the file turns out not to be a part of the image.
+	// Validators recorded in the manifest of the previous run are sent in
+	// "If-Range" header. Requires a Sink implementing ResumeSink, e.g. the
+	// output directory.
+	Resume bool
+	// WriteManifest save ManifestFilename with the list of images into the
+	// output directory.
+	WriteManifest bool
+	// ConditionalRequests send ETag and Last-Modified of images saved by the
+	// previous run, unchanged images are skipped. Validators are kept in the
+	// manifest, which is written as with WriteManifest. Requires the output
+	// directory, not a custom Sink.
+	ConditionalRequests bool
+	// Depth is the number of levels of linked pages to collect images from,
+	// zero means the page only.
+	Depth int
+	// FollowStylesheets fetch stylesheets linked or imported by the page and
+	// collect images they reference.
+	FollowStylesheets bool
+	// FollowImageLinks fetch pages linked by <a> elements wrapping an <img>,
+	// e.g. gallery thumbnails, and download the largest image of each page.
+	// Links to other hosts are followed only with AllowExternal.
+	FollowImageLinks bool
+	// AllowExternal follows links to other hosts when crawling.
+	AllowExternal bool
+	// IgnoreRobots fetch pages and images disallowed by robots.txt.
+	IgnoreRobots bool
+	// RateLimit is the maximum number of requests per second, zero means no
+	// limit.
+	RateLimit float64
+	// MaxRedirects is the number of redirects followed by a single request,
+	// zero means 10 and negative value disables redirects.
+	MaxRedirects int
+	// LazySrcAttributes and LazySrcsetAttributes are <img> attributes used by
+	// lazy loading scripts, they are preferred over `src` and `srcset`.
+	LazySrcAttributes    []string
+	LazySrcsetAttributes []string
+	// ListOnly report found images without downloading them, entries have
+	// no Filename.
+	ListOnly bool
+	// Sink stores downloaded images instead of the output directory.
+	Sink Sink
+	// MaxTotalBytes stops downloading once the total size of stored images
+	// exceeds it, zero means no limit.
+	MaxTotalBytes int64
+	// MaxPageBytes is the maximum size of a parsed HTML page, stylesheet or
+	// sitemap, larger ones fail with ErrPageTooLarge. Zero means no limit.
+	MaxPageBytes int64
+	// IncludeRegex keeps only images with matching URL, ExcludeRegex drops
+	// images with matching URL and takes precedence. Inline images are not
+	// filtered.
+	IncludeRegex *regexp.Regexp
+	ExcludeRegex *regexp.Regexp
+	// ElementTypes keeps only images found in elements of the given types,
+	// as reported in DownloadEntry.ElementType, e.g. "<img>" or "<picture>".
+	// Empty list keeps all images.
+	ElementTypes []string
+	// BlockedTypes skips images of the given extensions or media types, e.g.
+	// "svg" or "image/gif". Remote images are checked by the URL extension
+	// and again by the response Content-Type. Skipped images are reported as
+	// filtered, see DownloadEntry.Filtered.
+	BlockedTypes []string
+	// Handlers lists elements whose images are extracted, e.g. "img" or
+	// "source". Empty list extracts images from all supported elements.
+	// Images in style attributes are extracted regardless.
+	Handlers []string
+	// Limit is the maximum number of images downloaded, in the page order,
+	// zero means no limit.
+	Limit int
+	// FixExtensions replace the file extension when the downloaded content
+	// is an image of other format, e.g. PNG served from ".jpg" URL.
+	FixExtensions bool
+	// OrderedFeedback send entries of downloads in the page order instead of
+	// the order downloads finish. Downloads are still concurrent, entries of
+	// finished ones wait for the preceding images.
+	OrderedFeedback bool
+	// OnProgress is called after every finished download with the number of
+	// finished and total downloads. Calls are not concurrent.
+	OnProgress func(done, total int, entry DownloadEntry)
+	// OnFound is called once before downloads begin with the number of
+	// images which are going to be downloaded, e.g. to size a progress bar.
+	OnFound func(total int)
+	// OnStart is called when a download begins, before OnProgress of that
+	// image. The entry has the intended file name, which is empty for inline
+	// images with random names. Calls are not concurrent.
+	OnStart func(entry DownloadEntry)
+	// Logger receives diagnostic messages, nil discards them.
+	Logger Logger
+}
+
+// ExistingPolicy is the action taken when the file of the image already
+// exists, see Config.OnExisting.
+type ExistingPolicy int
+
+const (
+	// ExistingRename save the image under a new name, e.g. "photo (1).png".
+	ExistingRename ExistingPolicy = iota
+	// ExistingOverwrite replace the existing file.
+	ExistingOverwrite
+	// ExistingSkip do not download the image, the entry is reported as
+	// skipped.
+	ExistingSkip
+	// ExistingError do not download the image, the entry has an error
+	// matching os.ErrExist.
+	ExistingError
+)
+
+// onExisting return the policy taking Config.SkipExisting into account.
+func (c *Config) onExisting() ExistingPolicy {
+	if c.SkipExisting {
+		return ExistingSkip
+	}
+	return c.OnExisting
+}
+
+// workers return the maximum number of simultaneous downloads.
+func (c *Config) workers() int {
+	if c.Concurrency <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return c.Concurrency
+}
+
+// Logger receives diagnostic messages, e.g. *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// logf pass the message to Config.Logger, if set.
+func (c *Config) logf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+	}
+}
+
+// DefaultConfig return the configuration used by DownloadImages.
+func DefaultConfig() Config {
+	return Config{
+		Timeout:      defaultTimeout,
+		MaxAttempts:  defaultMaxAttempts,
+		RetryDelay:   defaultRetryDelay,
+		MaxPageBytes: defaultMaxPageBytes,
+		LazySrcAttributes: []string{
+			"data-src", "data-original", "data-lazy-src",
+		},
+		LazySrcsetAttributes: []string{"data-srcset", "data-lazy-srcset"},
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// crawl.go implements:
+//  - Collecting images from the page and pages it links to.
+
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+type crawlPage struct {
+	url   string
+	depth int
+	// page the crawl started from, links to other hosts are not followed
+	// unless Config.AllowExternal
+	start string
+}
+
+// pageLink return absolute URL of the linked page, or empty string for links
+// to images and non HTTP resources.
+func pageLink(pageURL, href string) string {
+	if IsDataURL(href) {
+		return ""
+	}
+	link, err := resolveURL(pageURL, href)
+	if err != nil {
+		return ""
+	}
+	parsedURL, err := url.Parse(link)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return ""
+	}
+	if ext := path.Ext(parsedURL.Path); len(ext) > 0 && IsImageExtension(ext[1:]) {
+		return ""
+	}
+	parsedURL.Fragment = ""
+
+	return parsedURL.String()
+}
+
+// pageLinks return absolute URLs of pages linked by <a> elements.
+func pageLinks(root *html.Node, pageURL string) []string {
+	var (
+		links []string
+		visit func(node *html.Node)
+	)
+
+	visit = func(node *html.Node) {
+		if node.Type == html.ElementNode && strings.ToLower(node.Data) == "a" {
+			if href, exist := getAttr(node, "href"); exist {
+				if link := pageLink(pageURL, href); len(link) > 0 {
+					links = append(links, link)
+				}
+			}
+		}
+		for n := node.FirstChild; n != nil; n = n.NextSibling {
+			visit(n)
+		}
+	}
+	visit(root)
+
+	return links
+}
+
+// ErrInvalidPageURL is returned for page URLs which are not absolute HTTP(S)
+// URLs.
+var ErrInvalidPageURL = errors.New("not an absolute HTTP(S) URL")
+
+// CheckPageURL return ErrInvalidPageURL for URLs which can not be fetched as
+// a page.
+func CheckPageURL(rawURL string) error {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPageURL, err)
+	}
+	if (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || len(parsedURL.Host) == 0 {
+		return fmt.Errorf("%q: %w", rawURL, ErrInvalidPageURL)
+	}
+	return nil
+}
+
+// sameHost compare host and port of URLs.
+func sameHost(a, b string) bool {
+	urlA, errA := url.Parse(a)
+	urlB, errB := url.Parse(b)
+	return errA == nil && errB == nil && strings.EqualFold(urlA.Host, urlB.Host)
+}
+
+// collectImages return images of `pages` and, up to Config.Depth levels, of
+// pages they link to. Failure of the page at `baseURL` is returned, failures
+// of other pages are passed to `report`, as are all failures when `baseURL` is
+// empty. The first page is fetched unless its parsed `first` document is
+// provided.
+func (s *session) collectImages(ctx context.Context, baseURL string, pages []string,
+	first *html.Node, report func(DownloadEntry)) ([]*elementConent, error) {
+	var (
+		contents []*elementConent
+		queue    []crawlPage
+		visited  = make(map[string]bool)
+		// stylesheets are shared by pages, fetch them once
+		stylesheets = make(map[string]bool)
+	)
+	for _, page := range pages {
+		if !visited[page] {
+			visited[page] = true
+			queue = append(queue, crawlPage{page, 0, page})
+		}
+	}
+
+	for len(queue) > 0 && ctx.Err() == nil {
+		page := queue[0]
+		queue = queue[1:]
+
+		var (
+			root = first
+			err  error
+		)
+		// `first` is the document of the first page
+		first = nil
+		if root == nil {
+			root, err = s.parseHTML(ctx, page.url)
+		}
+		if err != nil {
+			// failure of the requested page is fatal, of the other pages
+			// is reported
+			if len(baseURL) > 0 && page.url == baseURL {
+				return nil, err
+			}
+			// linked resource is not necessarily a web page
+			if !errors.Is(err, errNotHTML) {
+				report(DownloadEntry{Error: err, SourceURL: page.url})
+			}
+			continue
+		}
+		// relative links resolve against <base href> when the page declares it
+		base := documentBase(root, page.url)
+		contents = append(contents, iterateDOM(root, base, s.config,
+			enabledHandlers(s.config.Handlers), report)...)
+		if s.config.FollowStylesheets {
+			contents = append(contents, s.collectStylesheets(ctx,
+				stylesheetLinks(root, base), stylesheets, report)...)
+		}
+		if s.config.FollowImageLinks {
+			var links []string
+			for _, link := range galleryLinks(root, base) {
+				if s.config.AllowExternal || sameHost(page.start, link) {
+					links = append(links, link)
+				}
+			}
+			contents = append(contents, s.followGalleryLinks(ctx, links, report)...)
+		}
+
+		if page.depth >= s.config.Depth {
+			continue
+		}
+		for _, link := range pageLinks(root, base) {
+			if visited[link] || (!s.config.AllowExternal && !sameHost(page.start, link)) {
+				continue
+			}
+			visited[link] = true
+			queue = append(queue, crawlPage{link, page.depth + 1, page.start})
+		}
+	}
+
+	return contents, nil
+}
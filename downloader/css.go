@@ -0,0 +1,127 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// css.go implements:
+//  - Extracting url() references from CSS declarations.
+//  - Extracting images from inline `style` attributes and <style> elements.
+
+package downloader
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// matches url(...) with double, single or without quotes
+var cssURLPattern = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)\s]*))\s*\)`)
+
+// matches @import rule with URL either quoted or in url()
+var cssImportPattern = regexp.MustCompile(
+	`(?i)@import\s+(?:url\(\s*)?(?:"([^"]*)"|'([^']*)'|([^)\s;]*))\s*\)?[^;]*;?`)
+
+// parseCSSImports return URLs of stylesheets referenced by @import rules and
+// CSS text with these rules removed.
+func parseCSSImports(css string) ([]string, string) {
+	var urls []string
+	for _, match := range cssImportPattern.FindAllStringSubmatch(css, -1) {
+		for _, url := range match[1:] {
+			if len(url) > 0 {
+				urls = append(urls, url)
+				break
+			}
+		}
+	}
+
+	return urls, cssImportPattern.ReplaceAllString(css, "")
+}
+
+// parseCSSURLs return all url() references found in CSS text.
+func parseCSSURLs(css string) []string {
+	var urls []string
+	for _, match := range cssURLPattern.FindAllStringSubmatch(css, -1) {
+		for _, url := range match[1:] {
+			if len(url) > 0 {
+				urls = append(urls, url)
+				break
+			}
+		}
+	}
+
+	return urls
+}
+
+// parseCSSImageURL convert url() reference into image content, nil when it
+// is not an image. URLs without extension are considered images, since url()
+// in declarations like `background` or `list-style-image` refers to an image.
+// Malformed "data" URL is reported with *DataURLError.
+func parseCSSImageURL(url string, contentType elementType,
+	config *Config) (*elementConent, error) {
+	if IsDataURL(url) {
+		content := elementConent{contentType: contentType}
+		isImage, err := tryParseImageDataURL(url, &content)
+		if !isImage {
+			return nil, err
+		}
+		return &content, nil
+	}
+
+	ext := urlExt(url)
+	if len(ext) > 0 {
+		if ext = ext[1:]; !IsImageExtension(ext) {
+			return nil, nil
+		}
+	}
+	return &elementConent{contentType, dataURL, ext, url}, nil
+}
+
+// parseCSSImages convert url() references of CSS text into images. They are
+// returned along with the error of the first malformed "data" URL.
+func parseCSSImages(css string, contentType elementType,
+	config *Config) ([]*elementConent, error) {
+	var (
+		result   []*elementConent
+		firstErr error
+	)
+	for _, url := range parseCSSURLs(css) {
+		content, err := parseCSSImageURL(url, contentType, config)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if content != nil {
+			result = append(result, content)
+		}
+	}
+
+	return result, firstErr
+}
+
+// parseStyleAttr extract images from the `style` attribute of any element.
+func parseStyleAttr(node *html.Node, config *Config) ([]*elementConent, error) {
+	style, exist := getAttr(node, "style")
+	if !exist {
+		return nil, nil
+	}
+
+	return parseCSSImages(style, inlineStyleElement, config)
+}
+
+// parseStyleElement extract images from CSS rules of <style> element.
+// Imported stylesheets are fetched only with Config.FollowStylesheets.
+func parseStyleElement(node *html.Node, config *Config) ([]*elementConent, error) {
+	var css strings.Builder
+	for n := node.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == html.TextNode {
+			css.WriteString(n.Data)
+		}
+	}
+
+	imports, rules := parseCSSImports(css.String())
+	if !config.FollowStylesheets {
+		for _, url := range imports {
+			config.logf("Skipping stylesheet imported in <style>: %s", url)
+		}
+	}
+
+	return parseCSSImages(rules, styleElement, config)
+}
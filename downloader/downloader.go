@@ -1,7 +1,8 @@
 // Copyright (c) 2021 Bagrii Petro.
 //
 // downloader.go implements:
-//  - Extracting images from <a>, <img>, <svg>, <iframe>, <object>, <link>, <embed> elements.
+//  - Extracting images from <a>, <img>, <picture>, <svg>, <iframe>, <object>, <link>, <embed> elements.
+//  - Extracting images from <noscript> fallback markup.
 //  - Downloading images concurrently.
 //  - Parsing Data UL into internal representation: https://tools.ietf.org/html/rfc2397
 
@@ -10,23 +11,33 @@
 package downloader
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"context"
-	"runtime"
 	"errors"
 	"fmt"
-	"log"
+	"math/rand"
 	"os"
 	"io"
 	"mime"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
 	"path"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
 )
 
 type elementType int
@@ -37,7 +48,7 @@ type elementConent struct {
 	dataExt     string
 	data        string
 }
-type nodeParseCallback func(node *html.Node) (*elementConent, error)
+type nodeParseCallback func(node *html.Node, config *Config) ([]*elementConent, error)
 
 const (
 	aElement elementType = iota
@@ -48,6 +59,13 @@ const (
 	objectElemet
 	linkElement
 	embedElement
+	inlineStyleElement
+	videoElement
+	metaElement
+	styleElement
+	stylesheetElement
+	jsonLDElement
+	inputElement
 )
 
 const (
@@ -58,11 +76,33 @@ const (
 var domHandlers = map[string]nodeParseCallback{
 	"a": parseA, "img": parseIMG,
 	"svg": parseSVG, "iframe": parseIframe,
+	"source": parseSource,
 	"object": parseObject,
 	"link":   parseLink,
 	"embed":  parseEmbed,
+	"video":  parseVideo,
+	"meta":   parseMeta,
+	"style":  parseStyleElement,
+	"script": parseScript,
+	"input":  parseInput,
 }
 
+// enabledHandlers return domHandlers of the elements in `names`, all of them
+// when `names` is empty. Unknown names are ignored.
+func enabledHandlers(names []string) map[string]nodeParseCallback {
+	if len(names) == 0 {
+		return domHandlers
+	}
+
+	handlers := make(map[string]nodeParseCallback, len(names))
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if handler, found := domHandlers[name]; found {
+			handlers[name] = handler
+		}
+	}
+	return handlers
+}
 
 func (dt dataType) String() string {
 	switch dt {
@@ -94,6 +134,20 @@ func (element elementType) String() string {
 		return "<link>"
 	case embedElement:
 		return "<embed>"
+	case inlineStyleElement:
+		return "style attribute"
+	case videoElement:
+		return "<video>"
+	case metaElement:
+		return "<meta>"
+	case styleElement:
+		return "<style>"
+	case stylesheetElement:
+		return "stylesheet"
+	case jsonLDElement:
+		return "JSON-LD"
+	case inputElement:
+		return "<input>"
 	}
 
 	return "unknown element"
@@ -121,16 +175,19 @@ func resolveURL(baseURL, inURL string) (string, error) {
 	}
 
 	if len(parsedURL.Scheme) == 0 {
+		base, err := url.Parse(baseURL)
+		if err != nil {
+			return "", err
+		}
 		// handing URL that start with double slash: "//example.com"
 		if len(parsedURL.Host) > 0 {
-			// use https by default
-			parsedURL.Scheme = "https"
+			// inherit scheme of the page, use https when it is unknown
+			parsedURL.Scheme = base.Scheme
+			if len(parsedURL.Scheme) == 0 {
+				parsedURL.Scheme = "https"
+			}
 		} else {
 			// handling relative local path
-			base, err := url.Parse(baseURL)
-			if err != nil {
-				return "", err
-			}
 			parsedURL = base.ResolveReference(parsedURL)
 		}
 	}
@@ -138,34 +195,102 @@ func resolveURL(baseURL, inURL string) (string, error) {
 	return parsedURL.String(), nil
 }
 
+// documentBase return URL relative links of the document are resolved
+// against: href of the first <base> element or `pageURL` when there is none.
+func documentBase(root *html.Node, pageURL string) string {
+	var find func(node *html.Node) (string, bool)
+	find = func(node *html.Node) (string, bool) {
+		if node.Type == html.ElementNode && strings.ToLower(node.Data) == "base" {
+			if href, exist := getAttr(node, "href"); exist {
+				return href, true
+			}
+		}
+		for n := node.FirstChild; n != nil; n = n.NextSibling {
+			if href, found := find(n); found {
+				return href, true
+			}
+		}
+		return "", false
+	}
+
+	href, found := find(root)
+	if !found {
+		return pageURL
+	}
+	base, err := resolveURL(pageURL, strings.TrimSpace(href))
+	if err != nil {
+		return pageURL
+	}
+
+	return base
+}
+
+// ErrUnsupportedImageType is the error of "data" URL with image media type
+// which has no known extension.
+var ErrUnsupportedImageType = errors.New("unsupported image type")
+
+// ErrNotBase64 is the error of "data" URL with binary image which is not
+// base64 encoded. Only XML based images, e.g. SVG, may be percent-encoded.
+var ErrNotBase64 = errors.New("binary image is not base64 encoded")
+
+// DataURLError is the reason the "data" URL of an image was skipped.
+type DataURLError struct {
+	// URL is the beginning of the "data" URL.
+	URL string
+	Err error
+	// reported as DownloadEntry.ElementType, as images of the element are
+	element elementType
+}
+
+func (e *DataURLError) Error() string {
+	return fmt.Sprintf("data URL %s: %v", e.URL, e.Err)
+}
+
+func (e *DataURLError) Unwrap() error {
+	return e.Err
+}
+
+// tryParseImageDataURL decode the "data" URL into `content`, return false
+// with nil error if it is not an image. Images which can't be decoded fail
+// with *DataURLError of the `content` element type.
 func tryParseImageDataURL(url string, content *elementConent) (bool, error) {
-	var isImage bool
 	data, err := ParseDataURL(url)
+	if err != nil {
+		return false, &DataURLError{URL: shortDataURL(url), Err: err,
+			element: content.contentType}
+	}
+	if data.Type != "image" {
+		return false, nil
+	}
 
+	mimeType := data.Type + "/" + data.Subtype
+	exts, found := MimeTypeToExt[mimeType]
+	if !found {
+		return false, &DataURLError{URL: shortDataURL(url),
+			Err:     fmt.Errorf("%w: %s", ErrUnsupportedImageType, mimeType),
+			element: content.contentType}
+	}
+	// not base64 encoded data, e.g. SVG, is percent-encoded text
+	if !data.IsBase64 && !strings.HasSuffix(data.Subtype, "+xml") {
+		return false, &DataURLError{URL: shortDataURL(url),
+			Err:     fmt.Errorf("%w: %s", ErrNotBase64, mimeType),
+			element: content.contentType}
+	}
+	decoded, err := data.Decode()
 	if err != nil {
-		return false, err
-	}
-	if data.Type == "image" {
-		mimeType := data.Type + "/" + data.Subtype
-		exts, found := MimeTypeToExt[mimeType]
-		if isImage = found; isImage {
-			if !data.IsBase64 {
-				log.Printf("Mime type %s in an image, but without base64 propertie?", mimeType)
-			}
-			content.data = data.Data
-			// get the first one from extension list
-			content.dataExt = exts[0]
-			content.dataType = dataInline
-		} else {
-			log.Printf("Mime type for image not found for %s", mimeType)
-		}
+		return false, &DataURLError{URL: shortDataURL(url), Err: err,
+			element: content.contentType}
 	}
+	content.data = string(decoded)
+	// get the first one from extension list
+	content.dataExt = exts[0]
+	content.dataType = dataInline
 
-	return isImage, nil
+	return true, nil
 }
 
 func parseEmbeddableObject(node *html.Node, typeAttr string,
-	dataAttr string, contentType elementType) (*elementConent, error) {
+	dataAttr string, contentType elementType, config *Config) ([]*elementConent, error) {
 	data, exist := getAttr(node, dataAttr)
 	if !exist || len(data) == 0 {
 		return nil, fmt.Errorf("'%s' does not exists in %s element",
@@ -179,49 +304,49 @@ func parseEmbeddableObject(node *html.Node, typeAttr string,
 		if appType[0] != "image" {
 			return nil, nil
 		}
-		if exts, found := MimeTypeToExt[type_]; found {
-			mimeExt = exts[0]
-		} else {
-			log.Printf("Mime type is image, but can't match extension for %s\n", type_)
+		if mimeExt = mimeTypeExt(type_); len(mimeExt) == 0 {
+			config.logf("Mime type is image, but can't match extension for %s", type_)
 		}
 	}
 
 	if IsDataURL(data) {
-		content := elementConent{}
-		if isImage, _ := tryParseImageDataURL(data, &content); isImage {
-			content.contentType = contentType
-			return &content, nil
+		content := elementConent{contentType: contentType}
+		isImage, err := tryParseImageDataURL(data, &content)
+		if err != nil {
+			return nil, err
+		}
+		if isImage {
+			return []*elementConent{&content}, nil
 		}
-	} else if ext := path.Ext(data); len(ext) == 0 {
+	} else if ext := urlExt(data); len(ext) == 0 {
 		if exist {
-			return &elementConent{contentType, dataURL, mimeExt, data}, nil
+			return []*elementConent{{contentType, dataURL, mimeExt, data}}, nil
 		}
 	} else if ext = ext[1:]; IsImageExtension(ext) {
-		return &elementConent{contentType, dataURL, ext, data}, nil
+		return []*elementConent{{contentType, dataURL, ext, data}}, nil
 	}
 
 	return nil, nil
 
 }
 
-func parseA(node *html.Node) (*elementConent, error) {
-	var result *elementConent
+func parseA(node *html.Node, config *Config) ([]*elementConent, error) {
+	var result []*elementConent
 	var err error
 
 	if href, exist := getAttr(node, "href"); exist {
 		if IsDataURL(href) {
 			var isImage bool
-			content := elementConent{}
+			content := elementConent{contentType: aElement}
 			isImage, err = tryParseImageDataURL(href, &content)
 			if isImage {
-				content.contentType = aElement
-				result = &content
+				result = []*elementConent{&content}
 			}
-		} else if ext := filepath.Ext(href); len(ext) > 0 {
+		} else if ext := urlExt(href); len(ext) > 0 {
 			// remove leading dot
 			ext = ext[1:]
 			if IsImageExtension(ext) {
-				result = &elementConent{aElement, dataURL, ext, href}
+				result = []*elementConent{{aElement, dataURL, ext, href}}
 			}
 		}
 	} else {
@@ -231,74 +356,226 @@ func parseA(node *html.Node) (*elementConent, error) {
 	return result, err
 }
 
-func parseIMG(node *html.Node) (*elementConent, error) {
-	src, exist := getAttr(node, "src")
-	if !exist || len(src) == 0 {
-		return nil, errors.New("'src' attribute not found or empty in <img> element")
-	}
-
+func parseImageURL(src string, contentType elementType,
+	config *Config) (*elementConent, error) {
 	if IsDataURL(src) {
-		content := elementConent{}
-		if isImage, _ := tryParseImageDataURL(src, &content); isImage {
-			content.contentType = imgElement
+		content := elementConent{contentType: contentType}
+		isImage, err := tryParseImageDataURL(src, &content)
+		if err != nil {
+			return nil, err
+		}
+		if isImage {
 			return &content, nil
-		} else {
-			return nil, fmt.Errorf("unrecognized image in the Data URL %s", src)
 		}
-	} else {
-		ext := filepath.Ext(src)
-		if len(ext) > 0 {
-			// remove leading dot
-			ext = ext[1:]
-			if !IsImageExtension(ext) {
-				fmt.Printf("extension %s is not recognized as image extension.", ext)
-				ext = ""
+		return nil, fmt.Errorf("unrecognized image in the Data URL %s", shortDataURL(src))
+	}
+
+	ext := urlExt(src)
+	if len(ext) > 0 {
+		// remove leading dot
+		ext = ext[1:]
+		if !IsImageExtension(ext) {
+			config.logf("extension %s is not recognized as image extension", ext)
+			ext = ""
+		}
+	}
+	return &elementConent{contentType, dataURL, ext, src}, nil
+}
+
+// firstAttr return value of the first non-empty attribute from the list.
+func firstAttr(node *html.Node, names []string) string {
+	for _, name := range names {
+		if value, _ := getAttr(node, name); len(strings.TrimSpace(value)) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+// parseImageCandidates parse `src` and `srcset` attributes of the node into
+// list of images. Only the highest resolution candidate is returned, unless
+// all candidates were requested.
+func parseImageCandidates(node *html.Node, contentType elementType,
+	config *Config) ([]*elementConent, error) {
+	src, _ := getAttr(node, "src")
+	srcset, _ := getAttr(node, "srcset")
+
+	return parseCandidates(src, srcset, contentType, config)
+}
+
+// parseCandidates parse `src` URL and `srcset` list into list of images.
+func parseCandidates(src string, srcset string, contentType elementType,
+	config *Config) ([]*elementConent, error) {
+	candidates := parseSrcset(srcset)
+	if len(src) > 0 {
+		// `src` is an implicit "1x" candidate
+		candidates = append(candidates, srcsetCandidate{url: src, density: 1})
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("'src' or 'srcset' attribute not found or empty in %s element",
+			contentType)
+	}
+	if !config.AllSrcsetCandidates {
+		candidates = []srcsetCandidate{bestSrcsetCandidate(candidates)}
+	}
+
+	var (
+		result   []*elementConent
+		firstErr error
+	)
+	for _, candidate := range candidates {
+		content, err := parseImageURL(candidate.url, contentType, config)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
 			}
+			continue
+		}
+		result = append(result, content)
+	}
+	if len(result) == 0 {
+		return nil, firstErr
+	}
+
+	return result, nil
+}
+
+func parseIMG(node *html.Node, config *Config) ([]*elementConent, error) {
+	// lazy loading scripts keep a placeholder in `src` and `srcset`
+	src := firstAttr(node, config.LazySrcAttributes)
+	srcset := firstAttr(node, config.LazySrcsetAttributes)
+	if len(src) > 0 || len(srcset) > 0 {
+		return parseCandidates(src, srcset, imgElement, config)
+	}
+
+	return parseImageCandidates(node, imgElement, config)
+}
+
+func parseSource(node *html.Node, config *Config) ([]*elementConent, error) {
+	// <source> of <video> and <audio> is not an image
+	if node.Parent == nil || strings.ToLower(node.Parent.Data) != "picture" {
+		return nil, nil
+	}
+
+	type_, exist := getAttr(node, "type")
+	if exist && !strings.HasPrefix(type_, "image/") {
+		return nil, nil
+	}
+
+	contents, err := parseImageCandidates(node, pictureElement, config)
+	fillMissingExt(contents, mimeTypeExt(type_))
+
+	return contents, err
+}
+
+// sanitizeSVG return copy of the SVG without scripts and event handler
+// attributes.
+func sanitizeSVG(node *html.Node) *html.Node {
+	clone := &html.Node{Type: node.Type, DataAtom: node.DataAtom, Data: node.Data,
+		Namespace: node.Namespace}
+	for _, attr := range node.Attr {
+		if !strings.HasPrefix(strings.ToLower(attr.Key), "on") {
+			clone.Attr = append(clone.Attr, attr)
+		}
+	}
+	for n := node.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == html.ElementNode && strings.ToLower(n.Data) == "script" {
+			continue
+		}
+		clone.AppendChild(sanitizeSVG(n))
+	}
+
+	return clone
+}
+
+// hasChildElements report whether the node contains any element.
+func hasChildElements(node *html.Node) bool {
+	for n := node.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == html.ElementNode {
+			return true
 		}
-		return &elementConent{imgElement, dataURL, ext, src}, nil
 	}
+	return false
 }
 
-func parseSVG(node *html.Node) (*elementConent, error) {
+func parseSVG(node *html.Node, config *Config) ([]*elementConent, error) {
+	svg := sanitizeSVG(node)
+	// nothing to display
+	if !hasChildElements(svg) {
+		config.logf("skipping empty <svg>")
+		return nil, nil
+	}
 	var text strings.Builder
-	if err := html.Render(&text, node); err != nil {
+	if err := html.Render(&text, svg); err != nil {
 		return nil, err
 	}
-	return &elementConent{svgElement, dataInline,
-		"svg", text.String()}, nil
+	contents := []*elementConent{{svgElement, dataInline,
+		"svg", text.String()}}
+
+	// external resources referenced by the SVG, the saved file is broken
+	// without them
+	var visit func(node *html.Node)
+	visit = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			switch strings.ToLower(node.Data) {
+			case "image", "use":
+				// matches both href and xlink:href
+				href, exist := getAttr(node, "href")
+				href = strings.TrimSpace(href)
+				// fragment refers to an element of the same document
+				if exist && len(href) > 0 && !strings.HasPrefix(href, "#") {
+					if content, err := parseImageURL(href, svgElement, config); err == nil {
+						contents = append(contents, content)
+					}
+				}
+			}
+		}
+		for n := node.FirstChild; n != nil; n = n.NextSibling {
+			visit(n)
+		}
+	}
+	visit(node)
+
+	return contents, nil
 }
 
-func parseIframe(node *html.Node) (*elementConent, error) {
+func parseIframe(node *html.Node, config *Config) ([]*elementConent, error) {
 	src, exist := getAttr(node, "src")
 	if !exist || len(src) == 0 {
 		return nil, errors.New("'src' attribute not found or empty in <iframe> element")
 	}
 
 	if IsDataURL(src) {
-		content := elementConent{}
-		if isImage, _ := tryParseImageDataURL(src, &content); isImage {
-			content.contentType = iframeElement
-			return &content, nil
+		content := elementConent{contentType: iframeElement}
+		isImage, err := tryParseImageDataURL(src, &content)
+		if err != nil {
+			return nil, err
+		}
+		if isImage {
+			return []*elementConent{&content}, nil
 		}
-	} else if ext := path.Ext(src); len(ext) > 0 {
+	} else if ext := urlExt(src); len(ext) > 0 {
 		ext = ext[1:]
 		if IsImageExtension(ext) {
-			return &elementConent{iframeElement, dataURL, ext, src}, nil
+			return []*elementConent{{iframeElement, dataURL, ext, src}}, nil
 		}
 	}
 
 	return nil, nil
 }
 
-func parseObject(node *html.Node) (*elementConent, error) {
-	return parseEmbeddableObject(node, "type", "data", objectElemet)
+func parseObject(node *html.Node, config *Config) ([]*elementConent, error) {
+	return parseEmbeddableObject(node, "type", "data", objectElemet, config)
 }
 
-func parseEmbed(node *html.Node) (*elementConent, error) {
-	return parseEmbeddableObject(node, "type", "src", embedElement)
+func parseEmbed(node *html.Node, config *Config) ([]*elementConent, error) {
+	return parseEmbeddableObject(node, "type", "src", embedElement, config)
 }
 
-func parseLink(node *html.Node) (*elementConent, error) {
+func parseLink(node *html.Node, config *Config) ([]*elementConent, error) {
+	if isImagePreload(node) {
+		return parsePreloadLink(node, config)
+	}
 	href, exist := getAttr(node, "href")
 
 	if !exist || len(href) == 0 {
@@ -306,129 +583,1136 @@ func parseLink(node *html.Node) (*elementConent, error) {
 	}
 
 	if IsDataURL(href) {
-		content := elementConent{}
-		if isImage, _ := tryParseImageDataURL(href, &content); isImage {
-			content.contentType = linkElement
-			return &content, nil
+		content := elementConent{contentType: linkElement}
+		isImage, err := tryParseImageDataURL(href, &content)
+		if err != nil {
+			return nil, err
+		}
+		if isImage {
+			return []*elementConent{&content}, nil
+		}
+	} else if ext := urlExt(href); len(ext) > 0 && IsImageExtension(ext[1:]) {
+		return []*elementConent{{linkElement, dataURL, ext[1:], href}}, nil
+	} else if isIconLink(node) {
+		// icon URLs often have no extension, the type attribute hints it
+		type_, _ := getAttr(node, "type")
+		return []*elementConent{{linkElement, dataURL, mimeTypeExt(type_), href}}, nil
+	}
+
+	return nil, nil
+}
+
+// iconRelations are <link rel> values referencing site icons.
+var iconRelations = map[string]bool{
+	"icon":                         true,
+	"apple-touch-icon":             true,
+	"apple-touch-icon-precomposed": true,
+	"mask-icon":                    true,
+	"fluid-icon":                   true,
+}
+
+// isIconLink return whether <link> references a site icon. `rel` is a space
+// separated list, e.g. "shortcut icon".
+func isIconLink(node *html.Node) bool {
+	rel, _ := getAttr(node, "rel")
+	for _, relation := range strings.Fields(strings.ToLower(rel)) {
+		if iconRelations[relation] {
+			return true
+		}
+	}
+	return false
+}
+
+// isImagePreload return whether the <link> preloads an image, i.e. has
+// rel="preload" and as="image".
+func isImagePreload(node *html.Node) bool {
+	as, _ := getAttr(node, "as")
+	if !strings.EqualFold(strings.TrimSpace(as), "image") {
+		return false
+	}
+	rel, _ := getAttr(node, "rel")
+	for _, relation := range strings.Fields(strings.ToLower(rel)) {
+		if relation == "preload" {
+			return true
 		}
-	} else if ext := path.Ext(href); len(ext) > 0 {
+	}
+	return false
+}
+
+// parsePreloadLink return the preloaded image, `href` and `imagesrcset` are
+// candidates like `src` and `srcset` of <img>. URLs are images regardless of
+// extension, the `type` attribute hints it.
+func parsePreloadLink(node *html.Node, config *Config) ([]*elementConent, error) {
+	href, _ := getAttr(node, "href")
+	srcset, _ := getAttr(node, "imagesrcset")
+	contents, err := parseCandidates(href, srcset, linkElement, config)
+	type_, _ := getAttr(node, "type")
+	fillMissingExt(contents, mimeTypeExt(type_))
+
+	return contents, err
+}
+
+// fillMissingExt set the extension of images whose URL has none, e.g. derived
+// from the `type` attribute.
+func fillMissingExt(contents []*elementConent, ext string) {
+	for _, content := range contents {
+		if len(content.dataExt) == 0 {
+			content.dataExt = ext
+		}
+	}
+}
+
+// parseVideo return the `poster` image shown before the video plays.
+func parseVideo(node *html.Node, config *Config) ([]*elementConent, error) {
+	poster, exist := getAttr(node, "poster")
+	if !exist || len(poster) == 0 {
+		return nil, nil
+	}
+
+	if IsDataURL(poster) {
+		content := elementConent{contentType: videoElement}
+		isImage, err := tryParseImageDataURL(poster, &content)
+		if err != nil {
+			return nil, err
+		}
+		if isImage {
+			return []*elementConent{&content}, nil
+		}
+	} else if ext := urlExt(poster); len(ext) > 0 {
 		if ext = ext[1:]; IsImageExtension(ext) {
-			return &elementConent{linkElement, dataURL, ext, href}, nil
+			return []*elementConent{{videoElement, dataURL, ext, poster}}, nil
 		}
 	}
 
 	return nil, nil
 }
 
-func iterateDOM(root *html.Node, baseURL string,
-	callbacks map[string]nodeParseCallback) []*elementConent {
-	queue, elements := make([]*html.Node, 0), make([]*elementConent, 0)
+// parseInput return the image of <input type="image"> form button.
+func parseInput(node *html.Node, config *Config) ([]*elementConent, error) {
+	if type_, _ := getAttr(node, "type"); !strings.EqualFold(strings.TrimSpace(type_), "image") {
+		return nil, nil
+	}
+	src, exist := getAttr(node, "src")
+	if !exist || len(strings.TrimSpace(src)) == 0 {
+		return nil, errors.New("'src' attribute not found or empty in <input> element")
+	}
+
+	content, err := parseImageURL(strings.TrimSpace(src), inputElement, config)
+	if err != nil {
+		return nil, err
+	}
+	return []*elementConent{content}, nil
+}
+
+// metaImageProperties are Open Graph and Twitter card properties of the page
+// preview image.
+var metaImageProperties = map[string]bool{
+	"og:image":      true,
+	"og:image:url":  true,
+	"twitter:image": true,
+}
+
+// parseMeta return the preview image of Open Graph and Twitter card metadata.
+func parseMeta(node *html.Node, config *Config) ([]*elementConent, error) {
+	property, exist := getAttr(node, "property")
+	if !exist {
+		property, _ = getAttr(node, "name")
+	}
+	if !metaImageProperties[strings.ToLower(strings.TrimSpace(property))] {
+		return nil, nil
+	}
 
-	queue = append(queue, root)
+	content, exist := getAttr(node, "content")
+	if !exist || len(content) == 0 {
+		return nil, fmt.Errorf("'content' does not exists in <meta property=%q> element", property)
+	}
+	image, err := parseImageURL(strings.TrimSpace(content), metaElement, config)
+	if err != nil {
+		return nil, err
+	}
 
-	for len(queue) > 0 {
-		node := queue[0]
-		queue = queue[1:]
-		if callback, exist := callbacks[strings.ToLower(node.Data)]; exist {
-			if content, err := callback(node); err == nil && content != nil {
-				if content.dataType == dataURL {
-					if fullURL, err := resolveURL(baseURL, content.data); err == nil {
-						content.data = fullURL
+	return []*elementConent{image}, nil
+}
+
+// ErrInvalidURL is the error of images whose URL can't be resolved against
+// the page URL.
+var ErrInvalidURL = errors.New("invalid image URL")
+
+// iterateDOM return images of the document in the page order. Images whose
+// URL can't be resolved and "data" URLs which can't be decoded are dropped and
+// passed to `report`, unless it is nil.
+func iterateDOM(root *html.Node, baseURL string, config *Config,
+	callbacks map[string]nodeParseCallback, report func(DownloadEntry)) []*elementConent {
+	stack, elements := make([]*html.Node, 0), make([]*elementConent, 0)
+
+	appendContents := func(contents []*elementConent) {
+		for _, content := range contents {
+			if content.dataType == dataURL {
+				fullURL, err := resolveURL(baseURL, content.data)
+				if err != nil {
+					if report != nil {
+						report(DownloadEntry{
+							Error:       fmt.Errorf("%w %q: %v", ErrInvalidURL, content.data, err),
+							SourceURL:   content.data,
+							ElementType: content.contentType.String(),
+						})
 					}
+					continue
 				}
-				elements = append(elements, content)
+				content.data = fullURL
 			}
+			elements = append(elements, content)
+		}
+	}
+
+	stack = append(stack, root)
 
+	// depth-first, so elements are reported in the document order
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if callback, exist := callbacks[strings.ToLower(node.Data)]; exist {
+			contents, err := callback(node, config)
+			var dataErr *DataURLError
+			if err == nil {
+				appendContents(contents)
+			} else if errors.As(err, &dataErr) {
+				// other errors are missing attributes of elements, images of
+				// CSS are returned along with the malformed URL
+				appendContents(contents)
+				if report != nil {
+					report(DownloadEntry{Error: err,
+						ElementType: dataErr.element.String()})
+				}
+			}
 		}
-		for n := node.FirstChild; n != nil; n = n.NextSibling {
+		// any element can reference images in its inline style
+		contents, err := parseStyleAttr(node, config)
+		appendContents(contents)
+		if err != nil && report != nil {
+			report(DownloadEntry{Error: err, ElementType: inlineStyleElement.String()})
+		}
+		// pushed in reverse to pop the first child first
+		for n := node.LastChild; n != nil; n = n.PrevSibling {
 			if n.Type == html.ElementNode {
-				queue = append(queue, n)
+				stack = append(stack, n)
+			}
+		}
+		if node.DataAtom == atom.Noscript {
+			fallback := noscriptNodes(node)
+			for i := len(fallback) - 1; i >= 0; i-- {
+				if fallback[i].Type == html.ElementNode {
+					stack = append(stack, fallback[i])
+				}
 			}
 		}
 	}
 	return elements
 }
 
-func getHTTPClient(tlsvetify bool) *http.Client {
+// noscriptNodes return nodes of the <noscript> fallback markup. The parser
+// runs with scripting enabled, so the content of <noscript> is kept as text
+// and has to be parsed again.
+func noscriptNodes(node *html.Node) []*html.Node {
+	var text strings.Builder
+	for n := node.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == html.TextNode {
+			text.WriteString(n.Data)
+		}
+	}
+	if len(strings.TrimSpace(text.String())) == 0 {
+		return nil
+	}
+
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(text.String()), body)
+	if err != nil {
+		return nil
+	}
+	return nodes
+}
+
+// uniqueContents remove repeated images keeping the first occurrence. Remote
+// images are compared by resolved URL and inline images by content hash.
+func uniqueContents(contents []*elementConent) []*elementConent {
+	seen := make(map[string]bool)
+	result := make([]*elementConent, 0, len(contents))
+
+	for _, content := range contents {
+		key := content.data
+		if content.dataType == dataInline {
+			key = fmt.Sprintf("%x", sha256.Sum256([]byte(content.data)))
+		}
+		key = content.dataType.String() + ":" + key
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, content)
+		}
+	}
+
+	return result
+}
+
+// dialFunc opens network connections, see http.Transport.DialContext.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// resolveHostsDialer return dialer connecting to addresses of `hosts` instead
+// of the requested ones.
+func resolveHostsDialer(dial dialFunc, hosts map[string]string) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(ctx, network, addr)
+		}
+		if override, found := hosts[strings.ToLower(host)]; found {
+			addr = override
+			if _, _, err := net.SplitHostPort(override); err != nil {
+				addr = net.JoinHostPort(override, port)
+			}
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+func getHTTPClient(config Config) *http.Client {
 	customTransport := http.DefaultTransport.(*http.Transport).Clone()
-	// `tlsvetify` indocates whether to ignore expired or not valid certificate.
-	customTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: tlsvetify}
-	client := &http.Client{Transport: customTransport}
+	// indicates whether to ignore expired or not valid certificate.
+	customTransport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.DialContext != nil {
+		customTransport.DialContext = config.DialContext
+	}
+	if config.BlockPrivateNetworks {
+		// checks the address ResolveHosts points to as well
+		customTransport.DialContext = privateNetworkGuard(customTransport.DialContext)
+		// the proxy would connect to the host without the guard
+		customTransport.Proxy = nil
+	}
+	if len(config.ResolveHosts) > 0 {
+		customTransport.DialContext = resolveHostsDialer(customTransport.DialContext,
+			config.ResolveHosts)
+	}
+	// cookies set by the page are sent with image requests
+	jar := config.CookieJar
+	if jar == nil {
+		// never fails without options
+		jar, _ = cookiejar.New(nil)
+	}
+	var transport http.RoundTripper = customTransport
+	if config.Transport != nil {
+		transport = config.Transport
+	}
+	client := &http.Client{
+		Transport:     transport,
+		Timeout:       config.Timeout,
+		CheckRedirect: checkRedirect(config.MaxRedirects),
+		Jar:           jar,
+	}
 
 	return client
 }
 
-func downloadImage(content *elementConent, dir string) (string, error) {
-	client := getHTTPClient(true)
+// session holds the state shared by all downloads of a single run.
+type session struct {
+	config *Config
+	client *http.Client
+	sink   Sink
+
+	mu sync.Mutex
+	// file names taken by downloads of this session
+	reserved map[string]bool
+	// robots.txt rules by origin
+	robots map[string]*robotsCache
+	// download slots by host, limited by Config.MaxPerHost
+	hosts map[string]*semaphore.Weighted
+	// hosts credentials are sent to, see Config.AuthHosts
+	authHosts map[string]bool
+	// nil when requests are not rate limited
+	limiter *rate.Limiter
+	// bytes stored by all downloads, limited by Config.MaxTotalBytes
+	stored int64
+	// cancel downloads once Config.MaxTotalBytes is exceeded
+	cancel context.CancelFunc
+	// manifest entries of the previous run by URL, see
+	// Config.ConditionalRequests
+	validators map[string]ManifestEntry
+}
 
-	if content.dataType == dataInline {
-		file, err := os.CreateTemp(dir, "*."+content.dataExt)
-		if err != nil {
-			return "", err
+func newSession(config *Config, dir string) *session {
+	s := &session{
+		config:   config,
+		client:   getHTTPClient(*config),
+		sink:     config.Sink,
+		reserved: make(map[string]bool),
+		robots:   make(map[string]*robotsCache),
+		hosts:    make(map[string]*semaphore.Weighted),
+	}
+	if s.sink == nil {
+		s.sink = DirSink(dir)
+	}
+	policy := s.client.CheckRedirect
+	s.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		// redirect may lead to another host
+		if !s.sendsCredentials(req.URL) {
+			req.Header.Del("Authorization")
 		}
-		defer file.Close()
+		return policy(req, via)
+	}
+	if config.ConditionalRequests || config.Resume {
+		s.validators = previousValidators(s.sink)
+	}
+	if config.RateLimit > 0 {
+		s.limiter = rate.NewLimiter(rate.Limit(config.RateLimit), 1)
+	}
 
-		if _, err := file.WriteString(content.data); err != nil {
-			return "", err
+	return s
+}
+
+// acquireHost wait for a download slot of the URL host, see
+// Config.MaxPerHost. Returned function releases the slot.
+func (s *session) acquireHost(ctx context.Context, rawURL string) (func(), error) {
+	if s.config.MaxPerHost <= 0 {
+		return func() {}, nil
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := strings.ToLower(parsed.Host)
+
+	s.mu.Lock()
+	sem, found := s.hosts[host]
+	if !found {
+		sem = semaphore.NewWeighted(int64(s.config.MaxPerHost))
+		s.hosts[host] = sem
+	}
+	s.mu.Unlock()
+
+	if err := sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	return func() { sem.Release(1) }, nil
+}
+
+// exists return whether the file is already stored in the sink.
+func (s *session) exists(name string) bool {
+	if sink, ok := s.sink.(ExistSink); ok {
+		return sink.Exists(name)
+	}
+	return false
+}
+
+// outputName return the name reported to the caller, which is the file path
+// for DirSink.
+func (s *session) outputName(name string) string {
+	if sink, ok := s.sink.(DirSink); ok {
+		return sink.Path(name)
+	}
+	return name
+}
+
+// storedName is the reverse of outputName.
+func (s *session) storedName(output string) string {
+	if sink, ok := s.sink.(DirSink); ok {
+		if name, ok := sink.name(output); ok {
+			return name
 		}
-		return path.Join(dir, file.Name()), nil
+	}
+	return output
+}
+
+// budgetReader count bytes stored by the session and, like io.LimitReader,
+// never return more than Config.MaxTotalBytes in total, failing once the
+// source has more data than the limit allows.
+type budgetReader struct {
+	io.Reader
+	sess *session
+}
+
+func (r budgetReader) Read(p []byte) (int, error) {
+	limit := r.sess.config.MaxTotalBytes
+	remaining := limit - atomic.LoadInt64(&r.sess.stored)
+	if remaining < 0 {
+		remaining = 0
+	}
+	// a byte past the limit tells whether the source exceeds it
+	if int64(len(p)) > remaining+1 {
+		p = p[:remaining+1]
+	}
+
+	n, err := r.Reader.Read(p)
+	if stored := atomic.AddInt64(&r.sess.stored, int64(n)); stored > limit {
+		if r.sess.cancel != nil {
+			r.sess.cancel()
+		}
+		// truncate to the limit, the excess is not stored
+		excess := stored - limit
+		if excess > int64(n) {
+			excess = int64(n)
+		}
+		return n - int(excess), ErrTotalBytesExceeded
+	}
+	return n, err
+}
+
+// remainingBytes return how many bytes Config.MaxTotalBytes still allows to
+// store, -1 when there is no limit.
+func (s *session) remainingBytes() int64 {
+	if s.config.MaxTotalBytes <= 0 {
+		return -1
+	}
+	if remaining := s.config.MaxTotalBytes - atomic.LoadInt64(&s.stored); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// store write the file into the sink.
+func (s *session) store(name string, r io.Reader) (int64, error) {
+	file, err := s.sink.Create(name)
+	if err != nil {
+		return 0, err
+	}
+	return s.write(file, r)
+}
+
+// write copy `r` into the file and close it, applying Config.MaxTotalBytes.
+func (s *session) write(file io.WriteCloser, r io.Reader) (int64, error) {
+	if s.config.MaxTotalBytes > 0 {
+		r = budgetReader{r, s}
+	}
+	return copyFile(file, r)
+}
+
+// copyFile copy `r` into the file and close it, or abort it on failure when
+// the file implements Aborter. Config.MaxTotalBytes is not applied.
+func copyFile(file io.WriteCloser, r io.Reader) (int64, error) {
+	written, err := io.Copy(file, r)
+	if aborter, ok := file.(Aborter); ok && err != nil {
+		// partial content is discarded instead of being stored
+		aborter.Abort()
+		return written, err
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
+
+// reserveFilename return a file name which is neither in the sink nor taken by
+// another download, appending " (1)", " (2)", etc. before the extension.
+func (s *session) reserveFilename(filename string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ext := path.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	candidate := filename
+	for i := 1; ; i++ {
+		if !s.reserved[candidate] && !s.exists(candidate) {
+			break
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	s.reserved[candidate] = true
+
+	return candidate
+}
+
+// reserveExact reserve the file name, return false if it is already in the
+// sink or taken by another download.
+func (s *session) reserveExact(filename string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reserved[filename] || s.exists(filename) {
+		return false
+	}
+	s.reserved[filename] = true
+	return true
+}
+
+// reserveExisting reserve the file name even if the file exists in the sink,
+// false when it is taken by another download.
+func (s *session) reserveExisting(filename string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reserved[filename] {
+		return false
+	}
+	s.reserved[filename] = true
+	return true
+}
+
+// release make the reserved file name available to other downloads.
+func (s *session) release(filename string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.reserved, filename)
+}
+
+// reserveTempFilename return a random file name with the extension, the same
+// way os.CreateTemp does.
+func (s *session) reserveTempFilename(ext string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		candidate := strconv.FormatUint(uint64(rand.Uint32()), 10) + "." + ext
+		if !s.reserved[candidate] && !s.exists(candidate) {
+			s.reserved[candidate] = true
+			return candidate
+		}
+	}
+}
+
+type downloadResult struct {
+	filename string
+	written  int64
+	// the file already exists and was not downloaded again
+	skipped bool
+	// the image did not pass filters and was not saved
+	filtered bool
+	// validators of the response for conditional requests
+	etag         string
+	lastModified string
+	// Data URL of the inline image recorded in the manifest instead of a file
+	dataURL string
+}
+
+// responseExt return the image extension matching the response Content-Type,
+// sniffing the body when the header is missing.
+func responseExt(header http.Header, body *bufio.Reader) string {
+	mediatype, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		// Peek return available data along with an error for short bodies
+		data, _ := body.Peek(512)
+		mediatype, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	return mimeTypeExt(mediatype)
+}
+
+// fixExt return the file name with extension matching the image format
+// sniffed from the body, when they disagree.
+func fixExt(filename string, body *bufio.Reader) string {
+	data, _ := body.Peek(512)
+	mediatype, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	exts, found := MimeTypeToExt[mediatype]
+	if !found || !strings.HasPrefix(mediatype, "image/") {
+		return filename
+	}
+
+	ext := path.Ext(filename)
+	for _, known := range exts {
+		if strings.EqualFold(ext, "."+known) {
+			return filename
+		}
+	}
+	return strings.TrimSuffix(filename, ext) + "." + exts[0]
+}
+
+// imageName return the name of remote image in the sink, see imageFilename
+// and Config.PreservePaths.
+func (s *session) imageName(content *elementConent, index int) string {
+	filename := imageFilename(content, s.config.FilenameTemplate, index)
+	if s.config.PreservePaths {
+		filename = path.Join(urlDir(content.data), filename)
+	}
+	return filename
+}
+
+// inlineName return the name of inline image in the sink, empty when it is
+// random, see Config.InlineFilenameTemplate.
+func (s *session) inlineName(content *elementConent, index int) string {
+	if len(s.config.InlineFilenameTemplate) == 0 {
+		return ""
+	}
+	return inlineFilename(content, s.config.InlineFilenameTemplate, index)
+}
+
+// intendedName return the file name the image is going to be saved to before
+// the download starts, empty for inline images with random names. The name
+// may still change due to collisions, redirects or the response type.
+func (s *session) intendedName(content *elementConent, index int) string {
+	if content.dataType != dataURL {
+		if filename := s.inlineName(content, index); len(filename) > 0 {
+			return s.outputName(filename)
+		}
+		return ""
+	}
+	return s.outputName(s.imageName(content, index))
+}
+
+// checkExisting apply Config.OnExisting when the file is already in the
+// sink, `done` is false when the image has to be saved.
+func (s *session) checkExisting(filename string) (result downloadResult, done bool, err error) {
+	if !s.exists(filename) {
+		return downloadResult{}, false, nil
+	}
+	switch s.config.onExisting() {
+	case ExistingSkip:
+		return downloadResult{filename: s.outputName(filename), skipped: true}, true, nil
+	case ExistingError:
+		return downloadResult{}, true, fmt.Errorf("%s: %w", s.outputName(filename), os.ErrExist)
+	}
+	return downloadResult{}, false, nil
+}
+
+// downloadImage save the image into the session directory and apply filters,
+// `index` is the position of the image on the page starting from 1.
+func (s *session) downloadImage(ctx context.Context, content *elementConent,
+	index int) (downloadResult, error) {
+	if typeBlocked(s.config.BlockedTypes, content.dataExt, "") {
+		// blocked by the extension, the response is checked below otherwise
+		return downloadResult{filtered: true}, nil
+	}
+	if content.dataType == dataInline {
+		if int64(len(content.data)) < s.config.MinBytes {
+			return downloadResult{filtered: true}, nil
+		}
+		if s.config.MaxInlineBytes > 0 && int64(len(content.data)) > s.config.MaxInlineBytes {
+			return downloadResult{filename: s.outputName(ManifestFilename),
+				dataURL: inlineDataURL(content)}, nil
+		}
+		filename := s.inlineName(content, index)
+		if len(filename) == 0 {
+			filename = s.reserveTempFilename(content.dataExt)
+		} else {
+			if result, done, err := s.checkExisting(filename); done {
+				return result, err
+			}
+			if s.config.onExisting() != ExistingOverwrite || !s.reserveExisting(filename) {
+				filename = s.reserveFilename(filename)
+			}
+		}
+		return s.saveImage(filename, strings.NewReader(content.data))
 	} else if content.dataType == dataURL {
-		resp, err := client.Get(content.data)
+		filename := s.imageName(content, index)
+		if result, done, err := s.checkExisting(filename); done {
+			return result, err
+		}
+		requested := filename
+
+		// validators of the previous run, see Config.ConditionalRequests
+		previous, known := s.validators[content.data]
+		conditional := known && s.config.ConditionalRequests
+		header := make(http.Header)
+		if conditional {
+			if len(previous.ETag) > 0 {
+				header.Set("If-None-Match", previous.ETag)
+			}
+			if len(previous.LastModified) > 0 {
+				header.Set("If-Modified-Since", previous.LastModified)
+			}
+		}
+		// file left by an interrupted download and its size, see Config.Resume
+		var (
+			partial string
+			offset  int64
+		)
+		if sink, ok := s.sink.(ResumeSink); ok && s.config.Resume {
+			if size, found := sink.Size(filename); found && size > 0 &&
+				s.reserveExisting(filename) {
+				partial, offset = filename, size
+				header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+				// the whole image is sent when it has changed since
+				if known && s.storedName(previous.Filename) == filename {
+					if len(previous.ETag) > 0 && !strings.HasPrefix(previous.ETag, "W/") {
+						header.Set("If-Range", previous.ETag)
+					} else if len(previous.LastModified) > 0 {
+						header.Set("If-Range", previous.LastModified)
+					}
+				}
+			}
+		}
+
+		release, err := s.acquireHost(ctx, content.data)
 		if err != nil {
-			return "", err
+			return downloadResult{}, err
+		}
+		defer release()
+
+		resp, err := s.getWithHeader(ctx, content.data, header)
+		if err != nil {
+			return downloadResult{}, err
 		}
 		defer resp.Body.Close()
 
+		if conditional && resp.StatusCode == http.StatusNotModified {
+			return downloadResult{filename: previous.Filename, skipped: true,
+				etag: previous.ETag, lastModified: previous.LastModified}, nil
+		}
+		if offset > 0 {
+			first, last, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+			switch {
+			case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && ok &&
+				first < 0 && total == offset:
+				// nothing left to download, the file is complete
+				return downloadResult{filename: s.outputName(partial), skipped: true}, nil
+			case resp.StatusCode == http.StatusPartialContent && ok &&
+				first == offset && last == total-1:
+				result, err := s.appendImage(partial, offset, resp)
+				result.etag = resp.Header.Get("ETag")
+				result.lastModified = resp.Header.Get("Last-Modified")
+				return result, err
+			case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable ||
+				resp.StatusCode == http.StatusPartialContent:
+				// the file is not a part of the image, e.g. it is larger
+				resp.Body.Close()
+				header.Del("Range")
+				header.Del("If-Range")
+				if resp, err = s.getWithHeader(ctx, content.data, header); err != nil {
+					return downloadResult{}, err
+				}
+				defer resp.Body.Close()
+			}
+		}
 		if resp.StatusCode != http.StatusOK {
-			return "", fmt.Errorf("received response code, %d", resp.StatusCode)
+			return downloadResult{}, newHTTPStatusError(content.data, resp)
+		}
+		// some servers answer missing images with an error page or JSON
+		body := bufio.NewReader(resp.Body)
+		if mediatype, ok := responseImageType(resp.Header, body); !ok {
+			return downloadResult{}, fmt.Errorf("%s: %w: %s", content.data, ErrNotImage, mediatype)
+		}
+		if len(s.config.BlockedTypes) > 0 {
+			// URL extension may be missing or differ from the served type
+			mediatype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+			if typeBlocked(s.config.BlockedTypes, "", mediatype) {
+				return downloadResult{filtered: true}, nil
+			}
+		}
+		if resp.ContentLength >= 0 && resp.ContentLength < s.config.MinBytes {
+			return downloadResult{filtered: true}, nil
+		}
+		if finalURL := resp.Request.URL.String(); finalURL != content.data {
+			// name the file after the redirect target
+			redirected := *content
+			redirected.data = finalURL
+			content = &redirected
+			filename = s.imageName(content, index)
 		}
 
-		filename := path.Base(content.data)
-		if ext := path.Ext(filename); len(ext) == 0 && len(content.dataExt) > 0 {
-			filename += "." + content.dataExt
+		if len(content.dataExt) == 0 {
+			// URL has no image extension, derive it from the response
+			if ext := responseExt(resp.Header, body); len(ext) > 0 {
+				withExt := *content
+				withExt.dataExt = ext
+				filename = s.imageName(&withExt, index)
+			}
 		}
-		filename = path.Join(dir, filename)
-		file, err := os.Create(filename)
-		if err != nil {
-			return "", err
+		if s.config.FixExtensions {
+			// CDN may serve another format than the URL suggests
+			filename = fixExt(filename, body)
+		}
+		if filename != requested && len(partial) == 0 && !conditional {
+			// the final name is known only now, e.g. after redirect
+			if result, done, err := s.checkExisting(filename); done {
+				return result, err
+			}
 		}
-		defer file.Close()
 
-		if _, err = io.Copy(file, resp.Body); err != nil {
-			return "", err
+		var reader io.Reader = body
+		if s.config.MinBytes > 0 {
+			// size is unknown, read enough bytes before creating the file
+			head := make([]byte, s.config.MinBytes)
+			n, err := io.ReadFull(body, head)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return downloadResult{filtered: true}, nil
+			} else if err != nil {
+				return downloadResult{}, err
+			}
+			reader = io.MultiReader(bytes.NewReader(head[:n]), body)
 		}
 
-		return filename, nil
+		// the server ignored the range, the partial file is replaced by the
+		// whole image
+		target := partial
+		if len(target) == 0 && conditional && s.reserveExisting(s.storedName(previous.Filename)) {
+			// the image changed since the previous run, update its file
+			target = s.storedName(previous.Filename)
+		} else if len(target) == 0 && s.config.onExisting() == ExistingOverwrite &&
+			s.reserveExisting(filename) {
+			target = filename
+		} else if len(target) == 0 {
+			target = s.reserveFilename(filename)
+		}
+		result, err := s.saveImage(target, reader)
+		result.etag = resp.Header.Get("ETag")
+		result.lastModified = resp.Header.Get("Last-Modified")
+		return result, err
+	}
+	return downloadResult{}, fmt.Errorf("unknown data type: %s", content.dataType)
+}
+
+// appendImage continue the partial file of `offset` bytes with the body of
+// "206 Partial Content" response, which range starts at `offset`.
+func (s *session) appendImage(filename string, offset int64,
+	resp *http.Response) (downloadResult, error) {
+	file, err := s.sink.(ResumeSink).Append(filename)
+	if err != nil {
+		return downloadResult{}, fmt.Errorf("saving %s: %w", filename, err)
+	}
+	written, err := s.write(file, resp.Body)
+	if errors.Is(err, ErrTotalBytesExceeded) {
+		// the truncated file is kept
+		return downloadResult{filename: s.outputName(filename), written: offset + written},
+			fmt.Errorf("saving %s: %w", filename, err)
+	} else if err != nil {
+		return downloadResult{}, fmt.Errorf("saving %s: %w", filename, err)
 	}
-	return "", fmt.Errorf("unknown data type: %s", content.dataType)
+
+	return downloadResult{filename: s.outputName(filename), written: offset + written}, nil
 }
 
+// saveImage store the image into the sink unless it is discarded by
+// dimensions or animation filter. `filename` must be reserved.
+func (s *session) saveImage(filename string, r io.Reader) (downloadResult, error) {
+	if s.config.MinWidth > 0 || s.config.MinHeight > 0 {
+		var small bool
+		if small, r = imageSmallerThan(r, s.config.MinWidth, s.config.MinHeight); small {
+			return downloadResult{filtered: true}, nil
+		}
+	}
+	if s.config.SkipAnimated {
+		var animated bool
+		// larger images are truncated to the budget and can't be decoded
+		if animated, r = imageAnimated(r, s.remainingBytes()); animated {
+			return downloadResult{filtered: true}, nil
+		}
+	}
+
+	if s.config.HashNames {
+		return s.saveHashed(filename, r)
+	}
+
+	written, err := s.store(filename, r)
+	if errors.Is(err, ErrTotalBytesExceeded) && s.exists(filename) {
+		// the truncated file is kept unless the sink discarded it
+		return downloadResult{filename: s.outputName(filename), written: written},
+			fmt.Errorf("saving %s: %w", filename, err)
+	} else if err != nil {
+		return downloadResult{}, fmt.Errorf("saving %s: %w", filename, err)
+	}
+
+	return downloadResult{filename: s.outputName(filename), written: written}, nil
+}
+
+// saveHashed store the image named after SHA-256 of the content, see
+// Config.HashNames. The content is kept under a temporary name until it is
+// hashed, or in memory when the sink can't rename files. `filename` must be
+// reserved.
+func (s *session) saveHashed(filename string, r io.Reader) (downloadResult, error) {
+	var (
+		hash    = sha256.New()
+		temp    string
+		buffer  memoryFile
+		written int64
+		err     error
+	)
+	sink, renames := s.sink.(DirSink)
+	if renames {
+		temp = s.reserveTempFilename(strings.TrimPrefix(path.Ext(filename), "."))
+		defer s.release(temp)
+		written, err = s.store(temp, io.TeeReader(r, hash))
+	} else {
+		written, err = s.write(&buffer, io.TeeReader(r, hash))
+	}
+	discard := func() {
+		if renames {
+			sink.remove(temp)
+		}
+	}
+	if err != nil {
+		// truncated image has no content hash to be named after
+		discard()
+		return downloadResult{}, fmt.Errorf("saving %s: %w", filename, err)
+	}
+
+	s.release(filename)
+	filename = path.Join(path.Dir(filename),
+		hex.EncodeToString(hash.Sum(nil))+path.Ext(filename))
+	if !s.reserveExact(filename) {
+		// the same content is already saved
+		discard()
+		return downloadResult{filename: s.outputName(filename), skipped: true}, nil
+	}
+	if renames {
+		err = sink.rename(temp, filename)
+	} else {
+		// counted by Config.MaxTotalBytes when buffered
+		var file io.WriteCloser
+		if file, err = s.sink.Create(filename); err == nil {
+			_, err = copyFile(file, &buffer)
+		}
+	}
+	if err != nil {
+		discard()
+		return downloadResult{}, fmt.Errorf("saving %s: %w", filename, err)
+	}
+
+	return downloadResult{filename: s.outputName(filename), written: written}, nil
+}
+
+// memoryFile is a file kept in memory.
+type memoryFile struct{ bytes.Buffer }
+
+func (f *memoryFile) Close() error { return nil }
+
 // DownloadEntry represent downloaded file.
 type DownloadEntry struct {
 	Filename string
 	Error error
+	// SourceURL is the URL of the image, empty for images inlined into the
+	// page. For page fetching errors it is the page URL.
+	SourceURL string
+	// ElementType is the element the image was found in, e.g. "<img>".
+	ElementType string
+	// Skipped indicates that the file already exists and was not downloaded.
+	Skipped bool
+	// Filtered indicates that the image was discarded by filters applied to
+	// the response, e.g. Config.MinBytes or Config.BlockedTypes.
+	Filtered bool
+	// Bytes is the size of the saved file.
+	Bytes int64
+}
+
+// Summary represent the outcome of downloading images from a page.
+type Summary struct {
+	// Found is the number of images detected on the page.
+	Found      int
+	Downloaded int
+	Failed     int
+	Skipped    int
+	// Filtered is the number of images discarded by filters, see
+	// DownloadEntry.Filtered.
+	Filtered int
+	// Bytes is the total size of downloaded images.
+	Bytes   int64
+	Elapsed time.Duration
+}
+
+var errNotHTML = errors.New("incorrect media type")
+
+// ErrNotImage is the error of images served with other than image type, e.g.
+// "not found" HTML page with "200 OK" status.
+var ErrNotImage = errors.New("response is not an image")
+
+// isImageType return whether the media type is an image or has image
+// extension in MimeTypeToExt.
+func isImageType(mediatype string) bool {
+	if strings.HasPrefix(mediatype, "image/") {
+		return true
+	}
+	for _, ext := range MimeTypeToExt[mediatype] {
+		if IsImageExtension(ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseImageType return the media type of the response and whether it is
+// an image. Missing or generic binary type is sniffed from the body.
+func responseImageType(header http.Header, body *bufio.Reader) (string, bool) {
+	mediatype, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err == nil && mediatype != "application/octet-stream" &&
+		mediatype != "binary/octet-stream" {
+		return mediatype, isImageType(mediatype)
+	}
+
+	// Peek return available data along with an error for short bodies
+	data, _ := body.Peek(512)
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if isImageType(sniffed) || bytes.Contains(bytes.ToLower(data), []byte("<svg")) {
+		return sniffed, true
+	}
+	return sniffed, false
+}
+
+// media types of pages parsed for images
+var htmlMediaTypes = map[string]bool{
+	"text/html":             true,
+	"application/xhtml+xml": true,
+	"application/xml":       true,
 }
 
-func parseHTML(baseURL string) (*html.Node, error) {
-	client := getHTTPClient(true)
-	resp, err := client.Get(baseURL)
+// ErrTotalBytesExceeded is sent as the last entry when downloads were stopped
+// because Config.MaxTotalBytes was exceeded. It is also the error of the image
+// which exceeded the limit, that file is truncated to fit the limit and is
+// reported as the Filename of the entry. With Config.HashNames or a sink whose
+// files implement Aborter, e.g. ZipSink, it is removed.
+var ErrTotalBytesExceeded = errors.New("total size of downloaded images exceeded the limit")
+
+// ErrPageTooLarge is the error of pages larger than Config.MaxPageBytes.
+var ErrPageTooLarge = errors.New("page size exceeded the limit")
+
+// pageReader fail with ErrPageTooLarge once more than `limit` bytes are read.
+type pageReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func (p *pageReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.read > p.limit {
+		return n, fmt.Errorf("%w: more than %d bytes", ErrPageTooLarge, p.limit)
+	}
+	return n, err
+}
+
+// limitPage return `r` which fails with ErrPageTooLarge after
+// Config.MaxPageBytes.
+func (s *session) limitPage(r io.Reader) io.Reader {
+	if s.config.MaxPageBytes <= 0 {
+		return r
+	}
+	return &pageReader{r: io.LimitReader(r, s.config.MaxPageBytes+1),
+		limit: s.config.MaxPageBytes}
+}
+
+// parseHTML fetch the page with the configured headers and credentials and
+// parse it. Cancelling `ctx` aborts both, the context error is returned.
+func (s *session) parseHTML(ctx context.Context, baseURL string) (*html.Node, error) {
+	resp, err := s.get(ctx, baseURL)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("received response code, %d", resp.StatusCode)
+		return nil, newHTTPStatusError(baseURL, resp)
 	}
 
-	contentType := resp.Header.Get("Content-type")
-	if mediatype, _, err := mime.ParseMediaType(contentType); err != nil {
-		return nil, err
-	} else if mediatype != "text/html" {
-		return nil, fmt.Errorf("incorrect media type: %s", mediatype)
+	// a page without media type is parsed as HTML
+	if contentType := resp.Header.Get("Content-type"); len(contentType) > 0 {
+		if mediatype, _, err := mime.ParseMediaType(contentType); err != nil {
+			return nil, fmt.Errorf("%s: %w", baseURL, err)
+		} else if !htmlMediaTypes[mediatype] {
+			return nil, fmt.Errorf("%s: %w: %s", baseURL, errNotHTML, mediatype)
+		}
+	}
+
+	doc, err := parseHTMLReader(s.limitPage(resp.Body))
+	if err != nil {
+		if ctx.Err() != nil {
+			// reading the body was interrupted by cancellation
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("%s: %w", baseURL, err)
 	}
 
-	doc, err := html.Parse(resp.Body)
+	return doc, nil
+}
+
+// parseHTMLReader parse HTML document read from `r`.
+func parseHTMLReader(r io.Reader) (*html.Node, error) {
+	doc, err := html.Parse(r)
 	if err != nil {
 		return nil, err
 	}
@@ -438,38 +1722,341 @@ func parseHTML(baseURL string) (*html.Node, error) {
 
 // DownloadImages download all images from URL and save to directory.
 func DownloadImages(baseURL string, dir string, feedback chan DownloadEntry) {
+	DownloadImagesWithConfig(baseURL, dir, DefaultConfig(), feedback)
+}
+
+// DownloadImagesWithConfig download all images from URL and save to directory
+// using the provided configuration.
+func DownloadImagesWithConfig(baseURL string, dir string, config Config,
+	feedback chan DownloadEntry) {
+	DownloadImagesContext(context.Background(), baseURL, dir, config, feedback)
+}
+
+// DownloadImagesContext download all images from URL and save to directory.
+// Cancelling the context aborts in-flight downloads, no entries are sent
+// after cancellation.
+func DownloadImagesContext(ctx context.Context, baseURL string, dir string,
+	config Config, feedback chan DownloadEntry) {
+	defer close(feedback)
+
+	if _, err := downloadImages(ctx, pageSource{url: baseURL}, dir, config,
+		feedback); err != nil {
+		select {
+		case feedback <- DownloadEntry{Error: err, SourceURL: baseURL}:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// DownloadImagesWithSummary download all images from URL and save to
+// directory, return the summary once all downloads are finished. Failure to
+// fetch or parse the page is returned as error, failures of individual images
+// are sent to `feedback`, which may be nil when they are not needed.
+func DownloadImagesWithSummary(ctx context.Context, baseURL string, dir string,
+	config Config, feedback chan DownloadEntry) (Summary, error) {
+	if feedback != nil {
+		defer close(feedback)
+	}
+
+	return downloadImages(ctx, pageSource{url: baseURL}, dir, config, feedback)
+}
+
+// DownloadImagesFromSitemap download images of all pages listed by the
+// sitemap at `sitemapURL`, including sitemap index files, and save to
+// directory. Failure to fetch or parse the sitemap is returned as error,
+// failures of pages and images are sent to `feedback`, which may be nil when
+// they are not needed.
+func DownloadImagesFromSitemap(ctx context.Context, sitemapURL string, dir string,
+	config Config, feedback chan DownloadEntry) (Summary, error) {
+	if feedback != nil {
+		defer close(feedback)
+	}
+
+	return downloadImages(ctx, pageSource{url: sitemapURL, sitemap: true}, dir, config,
+		feedback)
+}
+
+// DownloadImagesMulti download images of all pages in `urls` and save to
+// directory. Images shared by the pages are downloaded once and
+// Config.Concurrency limits downloads of all pages together. Failures of
+// pages and images are sent to `feedback`, which may be nil when they are not
+// needed.
+func DownloadImagesMulti(ctx context.Context, urls []string, dir string,
+	config Config, feedback chan DownloadEntry) (Summary, error) {
+	if feedback != nil {
+		defer close(feedback)
+	}
+	if len(urls) == 0 {
+		return Summary{}, errors.New("no page URL")
+	}
+
+	return downloadImages(ctx, pageSource{pages: urls}, dir, config, feedback)
+}
+
+// DownloadImagesFromReader download all images from HTML document read from
+// `r` and save to directory. Relative links are resolved against `baseURL`.
+func DownloadImagesFromReader(r io.Reader, baseURL string, dir string,
+	feedback chan DownloadEntry) {
+	DownloadImagesFromReaderWithConfig(r, baseURL, dir, DefaultConfig(), feedback)
+}
+
+// DownloadImagesFromReaderWithConfig download all images from HTML document
+// read from `r` and save to directory using the provided configuration.
+func DownloadImagesFromReaderWithConfig(r io.Reader, baseURL string, dir string,
+	config Config, feedback chan DownloadEntry) {
+	defer close(feedback)
+
+	if _, err := downloadImages(context.Background(), pageSource{url: baseURL, page: r},
+		dir, config, feedback); err != nil {
+		feedback <- DownloadEntry{Error: err, SourceURL: baseURL}
+	}
+}
+
+// pageSource is where downloadImages collects images from.
+type pageSource struct {
+	// URL of the page or of the sitemap
+	url string
+	// the page is read from it instead of fetching when not nil
+	page io.Reader
+	// `url` is a sitemap listing the pages
+	sitemap bool
+	// pages fetched instead of `url`, which is empty then
+	pages []string
+}
+
+// downloadImages download images of the pages of `source`.
+func downloadImages(ctx context.Context, source pageSource, dir string,
+	config Config, feedback chan DownloadEntry) (Summary, error) {
 	var (
-		maxWorkers = runtime.GOMAXPROCS(0)
-		sem        = semaphore.NewWeighted(int64(maxWorkers))
+		baseURL    = source.url
+		maxWorkers = config.workers()
+		sess       = newSession(&config, dir)
+		start      = time.Now()
+		summary    Summary
+		mu         sync.Mutex
 	)
 
-	defer close(feedback)
+	send := func(entry DownloadEntry) {
+		if feedback == nil || ctx.Err() != nil {
+			return
+		}
+		select {
+		case feedback <- entry:
+		case <-ctx.Done():
+		}
+	}
+
+	if source.pages != nil {
+		// invalid pages are reported, the others are still downloaded
+		valid := make([]string, 0, len(source.pages))
+		for _, page := range source.pages {
+			if err := CheckPageURL(page); err != nil {
+				send(DownloadEntry{Error: err, SourceURL: page})
+				continue
+			}
+			valid = append(valid, page)
+		}
+		source.pages = valid
+	}
+	sess.authorizeHosts(append([]string{baseURL}, source.pages...))
+	if len(config.Cookies) > 0 {
+		for _, rawURL := range append([]string{baseURL}, source.pages...) {
+			if len(rawURL) == 0 {
+				continue
+			}
+			pageURL, err := url.Parse(rawURL)
+			if err != nil {
+				return summary, err
+			}
+			sess.client.Jar.SetCookies(pageURL, config.Cookies)
+		}
+	}
+	if config.Sink == nil && !config.ListOnly {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return summary, err
+		}
+	}
+	sem := semaphore.NewWeighted(int64(maxWorkers))
+
+
+	var root *html.Node
+	if source.page != nil {
+		var err error
+		if root, err = parseHTMLReader(source.page); err != nil {
+			summary.Elapsed = time.Since(start)
+			return summary, err
+		}
+	}
+	pages := []string{baseURL}
+	if source.pages != nil {
+		pages = source.pages
+	} else if source.sitemap {
+		var err error
+		if pages, err = sess.sitemapPages(ctx, baseURL, send); err != nil {
+			summary.Elapsed = time.Since(start)
+			return summary, err
+		}
+	}
 
-	root, err := parseHTML(baseURL)
-	
+	found, err := sess.collectImages(ctx, baseURL, pages, root, send)
 	if err != nil {
-		feedback <- DownloadEntry{Error: err}
-		return
+		summary.Elapsed = time.Since(start)
+		return summary, err
 	}
 
-	getImage := func(content *elementConent) {
+	contents := matchURLFilters(uniqueContents(found), config.IncludeRegex,
+		config.ExcludeRegex)
+	contents = matchElementTypes(contents, config.ElementTypes)
+	summary.Found = len(contents)
+	if config.Limit > 0 && len(contents) > config.Limit {
+		contents = contents[:config.Limit]
+	}
+	if config.OnFound != nil {
+		config.OnFound(len(contents))
+	}
+	if config.ListOnly {
+		for _, content := range matchBlockedTypes(contents, config.BlockedTypes) {
+			entry := DownloadEntry{ElementType: content.contentType.String()}
+			if content.dataType == dataURL {
+				entry.SourceURL = content.data
+			}
+			send(entry)
+		}
+		summary.Elapsed = time.Since(start)
+		return summary, nil
+	}
+	// filled by downloads in page order, validators of conditional requests
+	// are kept in the manifest as well
+	manifestEntries := make([]*ManifestEntry, len(contents))
+	writeManifestFile := config.WriteManifest || config.ConditionalRequests ||
+		config.MaxInlineBytes > 0
+	// cancelled when Config.MaxTotalBytes is exceeded
+	downloadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sess.cancel = cancel
+
+	// number of finished downloads, guarded by `mu`
+	done := 0
+	// entries waiting for the preceding images, see Config.OrderedFeedback,
+	// nil for images without entry
+	var (
+		orderMu  sync.Mutex
+		finished = make([]*DownloadEntry, len(contents))
+		ready    = make([]bool, len(contents))
+		next     = 0
+	)
+	emit := func(index int, entry *DownloadEntry) {
+		if !config.OrderedFeedback {
+			if entry != nil {
+				send(*entry)
+			}
+			return
+		}
+		orderMu.Lock()
+		defer orderMu.Unlock()
+		finished[index-1], ready[index-1] = entry, true
+		for ; next < len(ready) && ready[next]; next++ {
+			if finished[next] != nil {
+				send(*finished[next])
+			}
+		}
+	}
+	getImage := func(content *elementConent, index int) {
 		defer sem.Release(1)
 
-		filename, err := downloadImage(content, dir)
-		feedback <- DownloadEntry{filename, err}
-	}
+		if config.OnStart != nil {
+			entry := DownloadEntry{Filename: sess.intendedName(content, index),
+				ElementType: content.contentType.String()}
+			if content.dataType == dataURL {
+				entry.SourceURL = content.data
+			}
+			mu.Lock()
+			config.OnStart(entry)
+			mu.Unlock()
+		}
 
-	ctx := context.TODO()
-	for _, content := range iterateDOM(root, baseURL, domHandlers) {
-		if err := sem.Acquire(ctx, 1); err != nil {
-			feedback <- DownloadEntry{Error: err}
+		result, err := sess.downloadImage(downloadCtx, content, index)
+		if err != nil && downloadCtx.Err() != nil && ctx.Err() == nil &&
+			!errors.Is(err, ErrTotalBytesExceeded) {
+			// stopped due to Config.MaxTotalBytes, not a failure, the image
+			// which exceeded the limit is reported as truncated
+			emit(index, nil)
 			return
 		}
 
-		go getImage(content)
+		if writeManifestFile {
+			entry := newManifestEntry(content, result, err)
+			manifestEntries[index-1] = &entry
+		}
+
+		entry := DownloadEntry{Filename: result.filename, Error: err,
+			ElementType: content.contentType.String(),
+			Skipped:     result.skipped, Filtered: result.filtered,
+			Bytes:       result.written}
+		if content.dataType == dataURL {
+			entry.SourceURL = content.data
+		}
+		if err != nil {
+			config.logf("Failed to download %s image: %v", entry.ElementType, err)
+		}
+
+		mu.Lock()
+		if err != nil {
+			summary.Failed++
+		} else if result.skipped {
+			summary.Skipped++
+		} else if result.filtered {
+			summary.Filtered++
+		} else {
+			summary.Downloaded++
+			summary.Bytes += result.written
+		}
+		done++
+		if config.OnProgress != nil {
+			// called under the lock, so `done` is increasing
+			config.OnProgress(done, len(contents), entry)
+		}
+		mu.Unlock()
+
+		emit(index, &entry)
+	}
+
+	for i, content := range contents {
+		// fails only when context is cancelled
+		if err := sem.Acquire(downloadCtx, 1); err != nil {
+			break
+		}
+
+		go getImage(content, i+1)
 	}
 
-	if err := sem.Acquire(ctx, int64(maxWorkers)); err != nil {
-		feedback <- DownloadEntry{Error: err} 
+	// wait for in-flight downloads before closing feedback
+	sem.Acquire(context.Background(), int64(maxWorkers))
+	// images not started due to cancellation are never ready
+	for ; next < len(finished); next++ {
+		if finished[next] != nil {
+			send(*finished[next])
+		}
 	}
+
+	if config.MaxTotalBytes > 0 && atomic.LoadInt64(&sess.stored) > config.MaxTotalBytes {
+		send(DownloadEntry{Error: ErrTotalBytesExceeded})
+	}
+
+	if writeManifestFile {
+		manifest := Manifest{Page: baseURL, Pages: source.pages,
+			Images: make([]ManifestEntry, 0)}
+		for _, entry := range manifestEntries {
+			// nil when download was not started due to cancellation
+			if entry != nil {
+				manifest.Images = append(manifest.Images, *entry)
+			}
+		}
+		if err := writeManifest(sess.sink, manifest); err != nil {
+			send(DownloadEntry{Error: err})
+		}
+	}
+
+	summary.Elapsed = time.Since(start)
+	return summary, nil
 }
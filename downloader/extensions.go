@@ -1,15 +1,20 @@
 // Copyright (c) 2021 Bagrii Petro.
 //
 // extensions.go implements:
-// 	- List of most common image extensions;
+// 	- List of most common image extensions, which may be customized;
 //  - Mapping of MIME type to list of possible extensions.
 //  - Parsing Data URL into internal representation.
 
 package downloader
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/url"
+	"sort"
 	"strings"
+	"sync"
 )
 
 var extensions = [...]string{
@@ -111,6 +116,12 @@ var extensions = [...]string{
 	"xar",
 	"png",
 	"webp",
+	"avif",
+	"jxl",
+	"heic",
+	"heif",
+	"heics",
+	"heifs",
 	"jxr",
 	"hdp",
 	"wdp",
@@ -773,6 +784,12 @@ var MimeTypeToExt = map[string][]string{
 	"text/vnd.curl.dcurl":                                      {"dcurl"},
 	"application/x-ms-wmd":                                     {"wmd"},
 	"image/webp":                                               {"webp"},
+	"image/avif":                                               {"avif"},
+	"image/jxl":                                                {"jxl"},
+	"image/heic":                                               {"heic"},
+	"image/heif":                                               {"heif"},
+	"image/heic-sequence":                                      {"heics"},
+	"image/heif-sequence":                                      {"heifs"},
 	"application/javascript":                                   {"js"},
 	"application/srgs":                                         {"gram"},
 	"application/vnd.oasis.opendocument.presentation-template": {"otp"},
@@ -917,23 +934,104 @@ type DataURI struct {
 
 var imageExtensions map[string]bool = make(map[string]bool)
 
+// imageExtensionsMu guards imageExtensions modified by
+// RegisterImageExtensions and UnregisterImageExtensions.
+var imageExtensionsMu sync.RWMutex
+
 func init() {
 	for _, ext := range extensions {
 		imageExtensions[strings.ToLower(ext)] = true
 	}
 }
 
+// mimeTypeExt return the first extension of the MIME type, empty string when
+// it is unknown.
+func mimeTypeExt(type_ string) string {
+	if exts, found := MimeTypeToExt[type_]; found {
+		return exts[0]
+	}
+	return ""
+}
+
 // IsImageExtension return whether the extension is an image extension.
 func IsImageExtension(ext string) bool {
-	_, found := imageExtensions[strings.ToLower(ext)]
+	imageExtensionsMu.RLock()
+	defer imageExtensionsMu.RUnlock()
+
+	_, found := imageExtensions[strings.ToLower(strings.TrimPrefix(ext, "."))]
 	return found
 }
 
+// ImageExtensions return sorted list of recognized image extensions.
+func ImageExtensions() []string {
+	imageExtensionsMu.RLock()
+	defer imageExtensionsMu.RUnlock()
+
+	result := make([]string, 0, len(imageExtensions))
+	for ext := range imageExtensions {
+		result = append(result, ext)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// RegisterImageExtensions add extensions, e.g. "avif", to the set recognized
+// by IsImageExtension and the element parsers.
+func RegisterImageExtensions(exts ...string) {
+	imageExtensionsMu.Lock()
+	defer imageExtensionsMu.Unlock()
+
+	for _, ext := range exts {
+		imageExtensions[strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+}
+
+// UnregisterImageExtensions remove extensions, e.g. "svg", from the set
+// recognized by IsImageExtension and the element parsers.
+func UnregisterImageExtensions(exts ...string) {
+	imageExtensionsMu.Lock()
+	defer imageExtensionsMu.Unlock()
+
+	for _, ext := range exts {
+		delete(imageExtensions, strings.ToLower(strings.TrimPrefix(ext, ".")))
+	}
+}
+
 // IsDataURL return whether the URL is "data" URL
 func IsDataURL(url string) bool {
 	return strings.HasPrefix(url, "data:")
 }
 
+// MaxDataURLLength is the maximum length of "data" URL accepted by
+// ParseDataURL.
+const MaxDataURLLength = 32 << 20
+
+// ErrDataURLTooLong is returned by ParseDataURL for URLs longer than
+// MaxDataURLLength.
+var ErrDataURLTooLong = errors.New("data URL is too long")
+
+// shortDataURL return the beginning of the "data" URL for error messages.
+func shortDataURL(url string) string {
+	const maxLength = 64
+	if len(url) <= maxLength {
+		return url
+	}
+	return url[:maxLength] + "..."
+}
+
+// Decode return the payload of the "data" URL: base64 decoded when
+// IsBase64 is set, percent-unescaped otherwise.
+func (d DataURI) Decode() ([]byte, error) {
+	if d.IsBase64 {
+		return base64.StdEncoding.DecodeString(d.Data)
+	}
+	data, err := url.PathUnescape(d.Data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
 // ParseDataURL parse the "data" URL into components.
 func ParseDataURL(url string) (DataURI, error) {
 	const (
@@ -945,16 +1043,21 @@ func ParseDataURL(url string) (DataURI, error) {
 	)
 
 	if !IsDataURL(url) {
-		return DataURI{}, fmt.Errorf("input URL: %s is not correct Data URL", url)
+		return DataURI{}, fmt.Errorf("input URL: %s is not correct Data URL",
+			shortDataURL(url))
+	}
+	if len(url) > MaxDataURLLength {
+		return DataURI{}, fmt.Errorf("%w: %d bytes, limit is %d bytes", ErrDataURLTooLong,
+			len(url), MaxDataURLLength)
 	}
 
 	data := url[len(dataURIPrefix):]
-	if !strings.Contains(data, ",") {
-		return DataURI{}, fmt.Errorf("data not found in URL: %s", url)
+	comma := strings.IndexByte(data, ',')
+	if comma < 0 {
+		return DataURI{}, fmt.Errorf("data not found in URL: %s", shortDataURL(url))
 	}
 	// split propeties and actual encoded data
-	comp := strings.SplitN(data, ",", 2)
-	properties, encodedData := comp[0], comp[1]
+	properties, encodedData := data[:comma], data[comma+1:]
 
 	var result DataURI = DataURI{Data: encodedData,
 		Params: make(map[string]string)}
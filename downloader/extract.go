@@ -0,0 +1,109 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// extract.go implements:
+//  - Listing images of a page without downloading them.
+//  - Listing all "data" URLs of a page.
+
+package downloader
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// ImageRef represent an image found on a page.
+type ImageRef struct {
+	// URL is the resolved URL of the image, empty for inline images.
+	URL string
+	// ElementType is the element the image was found in, e.g. "<img>".
+	ElementType string
+	// Inline indicates that the image is embedded into the page as Data URL.
+	Inline bool
+	// Ext is the file extension implied by the element, may be empty.
+	Ext string
+	// Data is the decoded content of inline images.
+	Data []byte
+}
+
+// ExtractImages return images of the HTML document read from `r` in the page
+// order, repeated images are listed once. Relative links are resolved against
+// `baseURL`, images with invalid URLs are skipped.
+func ExtractImages(r io.Reader, baseURL string) ([]ImageRef, error) {
+	root, err := parseHTMLReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	config := DefaultConfig()
+	contents := uniqueContents(iterateDOM(root, documentBase(root, baseURL), &config,
+		domHandlers, nil))
+	images := make([]ImageRef, 0, len(contents))
+	for _, content := range contents {
+		image := ImageRef{ElementType: content.contentType.String(), Ext: content.dataExt}
+		if content.dataType == dataInline {
+			image.Inline = true
+			image.Data = []byte(content.data)
+		} else {
+			image.URL = content.data
+		}
+		images = append(images, image)
+	}
+
+	return images, nil
+}
+
+// ExtractDataURLs return every "data" URL of the HTML document read from `r`
+// in the page order, not only images. Attribute values, candidates of srcset
+// attributes and url() references of `style` attributes and <style> elements
+// are searched, malformed URLs are skipped.
+func ExtractDataURLs(r io.Reader) ([]DataURI, error) {
+	root, err := parseHTMLReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		result []DataURI
+		visit  func(node *html.Node)
+	)
+	add := func(value string) {
+		if value = strings.TrimSpace(value); !IsDataURL(value) {
+			return
+		}
+		if data, err := ParseDataURL(value); err == nil {
+			result = append(result, data)
+		}
+	}
+
+	visit = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			for _, attr := range node.Attr {
+				if strings.EqualFold(attr.Key, "style") {
+					for _, url := range parseCSSURLs(attr.Val) {
+						add(url)
+					}
+				} else if strings.HasSuffix(strings.ToLower(attr.Key), "srcset") {
+					// srcset, imagesrcset and their lazy loading variants
+					for _, candidate := range parseSrcset(attr.Val) {
+						add(candidate.url)
+					}
+				} else {
+					add(attr.Val)
+				}
+			}
+		} else if node.Type == html.TextNode && node.Parent != nil &&
+			strings.ToLower(node.Parent.Data) == "style" {
+			for _, url := range parseCSSURLs(node.Data) {
+				add(url)
+			}
+		}
+		for n := node.FirstChild; n != nil; n = n.NextSibling {
+			visit(n)
+		}
+	}
+	visit(root)
+
+	return result, nil
+}
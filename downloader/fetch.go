@@ -0,0 +1,314 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// fetch.go implements:
+//  - Sending GET requests, retrying failed ones with exponential backoff.
+//  - Decompressing gzip and deflate encoded responses.
+//  - Limiting the number of followed redirects.
+
+package downloader
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrTooManyRedirects is returned when a request is redirected more times
+// than Config.MaxRedirects allows.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// response headers kept by HTTPStatusError
+var statusErrorHeaders = []string{"Content-Type", "Retry-After", "WWW-Authenticate"}
+
+// HTTPStatusError is returned when the page or image is responded with status
+// other than "200 OK".
+type HTTPStatusError struct {
+	URL  string
+	Code int
+	// Status is the status line, e.g. "429 Too Many Requests".
+	Status string
+	// Header holds Content-Type, Retry-After and WWW-Authenticate headers of
+	// the response, when present.
+	Header http.Header
+}
+
+func newHTTPStatusError(url string, resp *http.Response) *HTTPStatusError {
+	err := &HTTPStatusError{URL: url, Code: resp.StatusCode, Status: resp.Status}
+	for _, name := range statusErrorHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			if err.Header == nil {
+				err.Header = make(http.Header)
+			}
+			err.Header[name] = values
+		}
+	}
+	return err
+}
+
+func (e *HTTPStatusError) Error() string {
+	if len(e.Status) > 0 {
+		return fmt.Sprintf("%s: received response, %s", e.URL, e.Status)
+	}
+	return fmt.Sprintf("%s: received response code, %d", e.URL, e.Code)
+}
+
+// RetryAfter return the delay requested by Retry-After header, given either
+// in seconds or as HTTP date.
+func (e *HTTPStatusError) RetryAfter() (time.Duration, bool) {
+	value := strings.TrimSpace(e.Header.Get("Retry-After"))
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// checkRedirect return http.Client.CheckRedirect policy following up to
+// `max` redirects, see Config.MaxRedirects.
+func checkRedirect(max int) func(*http.Request, []*http.Request) error {
+	if max == 0 {
+		max = defaultMaxRedirects
+	} else if max < 0 {
+		max = 0
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > max {
+			return fmt.Errorf("stopped after %d redirects: %w", max, ErrTooManyRedirects)
+		}
+		return nil
+	}
+}
+
+// authorizeHosts set hosts credentials are sent to: Config.AuthHosts, or
+// hosts of `pages` when it is empty.
+func (s *session) authorizeHosts(pages []string) {
+	s.authHosts = make(map[string]bool)
+	for _, host := range s.config.AuthHosts {
+		s.authHosts[strings.ToLower(host)] = true
+	}
+	if len(s.config.AuthHosts) > 0 {
+		return
+	}
+	for _, page := range pages {
+		if pageURL, err := neturl.Parse(page); err == nil && len(pageURL.Host) > 0 {
+			s.authHosts[strings.ToLower(pageURL.Host)] = true
+		}
+	}
+}
+
+// sendsCredentials return whether Config.Username, Password and BearerToken
+// are sent to the host of `u`, either with or without the port.
+func (s *session) sendsCredentials(u *neturl.URL) bool {
+	return s.authHosts[strings.ToLower(u.Host)] || s.authHosts[strings.ToLower(u.Hostname())]
+}
+
+// parseContentRange return the first and the last byte and the total size of
+// "bytes <first>-<last>/<total>" Content-Range, first and last are -1 for
+// "bytes */<total>" of unsatisfiable range. Unknown total is not accepted.
+func parseContentRange(value string) (first, last, total int64, ok bool) {
+	spec := strings.TrimPrefix(strings.TrimSpace(value), "bytes ")
+	parts := strings.SplitN(spec, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, 0, false
+	}
+	total, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	if parts[0] == "*" {
+		return -1, -1, total, true
+	}
+	bounds := strings.SplitN(parts[0], "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, 0, false
+	}
+	first, errFirst := strconv.ParseInt(bounds[0], 10, 64)
+	last, errLast := strconv.ParseInt(bounds[1], 10, 64)
+	if errFirst != nil || errLast != nil {
+		return 0, 0, 0, false
+	}
+	return first, last, total, true
+}
+
+// shouldRetry return whether the request may succeed when sent again:
+// network errors, "429 Too Many Requests" and server errors. Invalid
+// certificate, redirect loop or unsupported URL scheme is not going to be
+// fixed by retrying.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		var (
+			unknownAuthority x509.UnknownAuthorityError
+			invalid          x509.CertificateInvalidError
+			hostname         x509.HostnameError
+			urlErr           *neturl.Error
+			netErr           net.Error
+		)
+		if errors.As(err, &unknownAuthority) || errors.As(err, &invalid) ||
+			errors.As(err, &hostname) || errors.Is(err, ErrTooManyRedirects) ||
+			errors.Is(err, ErrPrivateAddress) {
+			return false
+		}
+		// url.Error implements net.Error whatever it wraps
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		// connection closed by the server before the response
+		return errors.As(err, &netErr) || errors.Is(err, io.EOF) ||
+			errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// backoff return delay before the attempt (starting from 1) as exponentially
+// growing `base` with random jitter of ±50%.
+func backoff(base time.Duration, attempt int) time.Duration {
+	delay := base << uint(attempt-1)
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay)))
+}
+
+// decodingReadCloser close both decompressor and response body.
+type decodingReadCloser struct {
+	io.Reader
+	body io.Closer
+}
+
+func (r decodingReadCloser) Close() error {
+	if closer, ok := r.Reader.(io.Closer); ok {
+		closer.Close()
+	}
+	return r.body.Close()
+}
+
+// decodeBody wrap the response body into decompressor matching
+// Content-Encoding. Transport does it on its own only when Accept-Encoding
+// was not set explicitly.
+func decodeBody(resp *http.Response) error {
+	var (
+		decoded io.Reader
+		err     error
+	)
+	body := bufio.NewReader(resp.Body)
+
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip", "x-gzip":
+		decoded, err = gzip.NewReader(body)
+	case "deflate":
+		// "deflate" is zlib format, though some servers send raw deflate
+		if header, _ := body.Peek(2); len(header) == 2 && header[0]&0x0f == 8 &&
+			(uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			decoded, err = zlib.NewReader(body)
+		} else {
+			decoded = flate.NewReader(body)
+		}
+	default:
+		return nil
+	}
+	if err == io.EOF {
+		// empty body
+		decoded, err = body, nil
+	} else if err != nil {
+		return err
+	}
+
+	resp.Body = decodingReadCloser{decoded, resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+
+	return nil
+}
+
+// get send GET request unless robots.txt disallows the URL.
+func (s *session) get(ctx context.Context, url string) (*http.Response, error) {
+	return s.getWithHeader(ctx, url, nil)
+}
+
+// getWithHeader send GET request with additional header, unless robots.txt
+// disallows the URL.
+func (s *session) getWithHeader(ctx context.Context, url string,
+	header http.Header) (*http.Response, error) {
+	if !s.config.IgnoreRobots && !s.robotsAllowed(ctx, url) {
+		return nil, fmt.Errorf("%s: %w", url, ErrDisallowedByRobots)
+	}
+	return s.fetch(ctx, url, header)
+}
+
+// fetch send GET request, retrying up to Config.MaxAttempts times. Response
+// of the last attempt is returned, status code is not checked.
+func (s *session) fetch(ctx context.Context, url string,
+	header http.Header) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		if s.limiter != nil {
+			if err := s.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range s.config.Headers {
+			req.Header.Set(name, value)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if len(s.config.UserAgent) > 0 {
+			req.Header.Set("User-Agent", s.config.UserAgent)
+		}
+		// credentials are not leaked to hosts the page links to
+		if auth := s.sendsCredentials(req.URL); auth &&
+			(len(s.config.Username) > 0 || len(s.config.Password) > 0) {
+			req.SetBasicAuth(s.config.Username, s.config.Password)
+		} else if auth && len(s.config.BearerToken) > 0 {
+			req.Header.Set("Authorization", "Bearer "+s.config.BearerToken)
+		}
+		resp, err := s.client.Do(req)
+		if err == nil {
+			if err = decodeBody(resp); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+		if attempt >= s.config.MaxAttempts || ctx.Err() != nil || !shouldRetry(resp, err) {
+			return resp, err
+		}
+		if err == nil {
+			resp.Body.Close()
+			s.config.logf("Retrying %s after response code %d", url, resp.StatusCode)
+		} else {
+			s.config.logf("Retrying %s after error: %v", url, err)
+		}
+
+		select {
+		case <-time.After(backoff(s.config.RetryDelay, attempt)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// filename.go implements:
+//  - Computing file names of downloaded images, optionally from a template.
+//  - Sanitizing file names and limiting their length.
+//  - Getting path and extension of URL, ignoring query and fragment.
+
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// characters not allowed in file names on common file systems
+const illegalFilenameChars = `/\:*?"<>|`
+
+// sanitizeFilename replace characters not allowed in file names with "_",
+// remove leading dots, so neither ".." nor hidden file is produced, and
+// trailing dots and spaces.
+func sanitizeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(illegalFilenameChars, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	return strings.TrimRight(strings.TrimLeft(name, "."), ". ")
+}
+
+// maxFilenameLength is the maximum length of file name in bytes. Most file
+// systems allow 255 bytes, the rest is left for the " (N)" suffix of
+// colliding names.
+const maxFilenameLength = 240
+
+// truncateFilename shorten the name to maxFilenameLength bytes keeping its
+// extension. The cut part is replaced with a hash of the whole name, so
+// different long names remain different.
+func truncateFilename(name string) string {
+	if len(name) <= maxFilenameLength {
+		return name
+	}
+	ext := path.Ext(name)
+	if len(ext) > 16 {
+		// not an extension, just a dot in the name
+		ext = ""
+	}
+	hash := sha256.Sum256([]byte(name))
+	suffix := "-" + hex.EncodeToString(hash[:4]) + ext
+	base := name[:maxFilenameLength-len(suffix)]
+	// do not split a multi-byte character
+	for !utf8.ValidString(base) {
+		base = base[:len(base)-1]
+	}
+	return base + suffix
+}
+
+// urlPath return the path of URL without query and fragment, e.g.
+// "/img/photo.jpg" for "/img/photo.jpg?v=1#top".
+func urlPath(rawURL string) string {
+	if parsedURL, err := url.Parse(rawURL); err == nil {
+		return parsedURL.EscapedPath()
+	}
+	if i := strings.IndexAny(rawURL, "?#"); i >= 0 {
+		return rawURL[:i]
+	}
+	return rawURL
+}
+
+// urlDir return directory of URL path as relative path of decoded and
+// sanitized segments, e.g. "assets/img" for "/assets/img/logo.png". Segments
+// like ".." are dropped.
+func urlDir(rawURL string) string {
+	var segments []string
+	for _, segment := range strings.Split(path.Dir(urlPath(rawURL)), "/") {
+		if decoded, err := url.PathUnescape(segment); err == nil {
+			segment = decoded
+		}
+		if segment = sanitizeFilename(segment); len(segment) > 0 {
+			segments = append(segments, truncateFilename(segment))
+		}
+	}
+	return path.Join(segments...)
+}
+
+// urlExt return the extension of URL path, including the dot.
+func urlExt(rawURL string) string {
+	return path.Ext(urlPath(rawURL))
+}
+
+// imageFilename compute the file name for remote image. When template is
+// empty the decoded and sanitized URL base name is used, appending extension
+// if it is missing. Long names are truncated, see truncateFilename.
+// Template supports {host}, {index}, {basename} and {ext} tokens.
+func imageFilename(content *elementConent, template string, index int) string {
+	filename := path.Base(urlPath(content.data))
+	if decoded, err := url.PathUnescape(filename); err == nil {
+		filename = decoded
+	}
+	if filename = sanitizeFilename(filename); len(filename) == 0 {
+		filename = "image"
+	}
+	ext := path.Ext(filename)
+	if len(content.dataExt) > 0 && (len(ext) == 0 || !IsImageExtension(ext[1:])) {
+		// URL has no image extension, e.g. "image.php?type=png"
+		filename = strings.TrimSuffix(filename, ext)
+		ext = ""
+	}
+	if len(template) == 0 {
+		if len(ext) == 0 && len(content.dataExt) > 0 {
+			filename += "." + content.dataExt
+		}
+		return truncateFilename(filename)
+	}
+
+	var host string
+	if parsedURL, err := url.Parse(content.data); err == nil {
+		host = parsedURL.Hostname()
+	}
+	basename := strings.TrimSuffix(filename, ext)
+	if len(ext) > 0 {
+		ext = ext[1:]
+	} else {
+		ext = content.dataExt
+	}
+
+	replacer := strings.NewReplacer(
+		"{host}", host,
+		"{index}", strconv.Itoa(index),
+		"{basename}", basename,
+		"{ext}", ext,
+	)
+	return truncateFilename(sanitizeFilename(replacer.Replace(template)))
+}
+
+// inlineFilename compute the file name for inline image from the template
+// with {index}, {hash} (SHA-256 of the content) and {ext} tokens.
+func inlineFilename(content *elementConent, template string, index int) string {
+	hash := sha256.Sum256([]byte(content.data))
+	replacer := strings.NewReplacer(
+		"{index}", strconv.Itoa(index),
+		"{hash}", hex.EncodeToString(hash[:]),
+		"{ext}", content.dataExt,
+	)
+	return truncateFilename(sanitizeFilename(replacer.Replace(template)))
+}
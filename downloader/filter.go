@@ -0,0 +1,179 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// filter.go implements:
+//  - Filtering downloaded images by dimensions.
+//  - Detecting animated GIF images.
+//  - Filtering images by URL patterns, element types and blocked types.
+
+package downloader
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	// register decoders for image.DecodeConfig
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// gifMagic starts every GIF image.
+var gifMagic = []byte("GIF8")
+
+// imageSmallerThan return whether the image read from `r` is narrower than
+// `minWidth` or lower than `minHeight`. Formats which can't be decoded (e.g.
+// SVG) are never considered small. The returned reader yields the whole
+// image, including the header consumed to get its dimensions.
+func imageSmallerThan(r io.Reader, minWidth, minHeight int) (bool, io.Reader) {
+	var head bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &head))
+	rest := io.MultiReader(&head, r)
+	if err != nil {
+		// unknown or corrupted format
+		return false, rest
+	}
+
+	return config.Width < minWidth || config.Height < minHeight, rest
+}
+
+// imageAnimated return whether the image read from `r` is a GIF with more than
+// one frame. Other formats are never considered animated, as are GIF images
+// larger than `limit` bytes unless it is negative. The returned reader yields
+// the whole image.
+func imageAnimated(r io.Reader, limit int64) (bool, io.Reader) {
+	head := make([]byte, len(gifMagic))
+	n, _ := io.ReadFull(r, head)
+	head = head[:n]
+	if !bytes.Equal(head, gifMagic) {
+		return false, io.MultiReader(bytes.NewReader(head), r)
+	}
+
+	// all frames have to be decoded to count them, the image is buffered up
+	// to the limit
+	body := r
+	if limit >= 0 {
+		body = io.LimitReader(r, limit-int64(n)+1)
+	}
+	data, err := io.ReadAll(body)
+	data = append(head, data...)
+	if limit >= 0 && int64(len(data)) > limit {
+		return false, io.MultiReader(bytes.NewReader(data), r)
+	}
+	rest := io.MultiReader(bytes.NewReader(data), errReader{err})
+	if err != nil {
+		return false, rest
+	}
+	animation, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		// corrupted image
+		return false, rest
+	}
+
+	return len(animation.Image) > 1, rest
+}
+
+// errReader return the error from every Read, nil error means io.EOF.
+type errReader struct{ err error }
+
+func (r errReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	return 0, io.EOF
+}
+
+// matchURLFilters return contents with URLs matching `include`, unless they
+// match `exclude` as well. Nil pattern is not applied, inline images are
+// always kept.
+func matchURLFilters(contents []*elementConent, include,
+	exclude *regexp.Regexp) []*elementConent {
+	if include == nil && exclude == nil {
+		return contents
+	}
+
+	result := make([]*elementConent, 0, len(contents))
+	for _, content := range contents {
+		if content.dataType == dataURL {
+			if exclude != nil && exclude.MatchString(content.data) {
+				continue
+			}
+			if include != nil && !include.MatchString(content.data) {
+				continue
+			}
+		}
+		result = append(result, content)
+	}
+
+	return result
+}
+
+// matchElementTypes return contents found in elements of the given types,
+// e.g. "<img>". Empty list keeps all contents.
+func matchElementTypes(contents []*elementConent, types []string) []*elementConent {
+	if len(types) == 0 {
+		return contents
+	}
+
+	result := make([]*elementConent, 0, len(contents))
+	for _, content := range contents {
+		for _, name := range types {
+			if content.contentType.String() == name {
+				result = append(result, content)
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// typeBlocked return whether the extension `ext` or the media type
+// `mediaType`, either may be empty, is in `blocked` list of extensions and
+// media types. Extension entry blocks media types it is registered for in
+// MimeTypeToExt and vice versa.
+func typeBlocked(blocked []string, ext, mediaType string) bool {
+	ext, mediaType = strings.ToLower(ext), strings.ToLower(mediaType)
+	for _, entry := range blocked {
+		entry = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(entry), "."))
+		if len(entry) == 0 {
+			// would match every image without extension
+			continue
+		} else if strings.Contains(entry, "/") {
+			if entry == mediaType || containsString(MimeTypeToExt[entry], ext) {
+				return true
+			}
+		} else if entry == ext || containsString(MimeTypeToExt[mediaType], entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// matchBlockedTypes return contents whose extension is not blocked, see
+// typeBlocked, for listing with Config.ListOnly. Remote images without known
+// extension are kept.
+func matchBlockedTypes(contents []*elementConent, blocked []string) []*elementConent {
+	if len(blocked) == 0 {
+		return contents
+	}
+
+	result := make([]*elementConent, 0, len(contents))
+	for _, content := range contents {
+		if !typeBlocked(blocked, content.dataExt, "") {
+			result = append(result, content)
+		}
+	}
+
+	return result
+}
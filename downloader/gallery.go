@@ -0,0 +1,155 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// gallery.go implements:
+//  - Following gallery thumbnails to pages of the full-size images.
+
+package downloader
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+	"golang.org/x/sync/semaphore"
+)
+
+// galleryLinks return absolute URLs of pages linked by <a> elements wrapping
+// an <img>, e.g. gallery thumbnails. Every page is listed once.
+func galleryLinks(root *html.Node, pageURL string) []string {
+	var (
+		links []string
+		seen  = make(map[string]bool)
+		visit func(node *html.Node)
+	)
+
+	visit = func(node *html.Node) {
+		if node.Type == html.ElementNode && strings.ToLower(node.Data) == "a" {
+			href, exist := getAttr(node, "href")
+			if link := pageLink(pageURL, href); exist && len(link) > 0 &&
+				!seen[link] && containsElement(node, "img") {
+				seen[link] = true
+				links = append(links, link)
+			}
+			// <a> can't be nested
+			return
+		}
+		for n := node.FirstChild; n != nil; n = n.NextSibling {
+			visit(n)
+		}
+	}
+	visit(root)
+
+	return links
+}
+
+// containsElement return whether the node has a descendant element with the
+// given name.
+func containsElement(node *html.Node, name string) bool {
+	for n := node.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == html.ElementNode &&
+			(strings.ToLower(n.Data) == name || containsElement(n, name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// imageArea return the area of <img> by its `width` and `height`
+// attributes, zero when the size is not declared.
+func imageArea(node *html.Node) int {
+	width, _ := getAttr(node, "width")
+	height, _ := getAttr(node, "height")
+	w, errW := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(width), "px"))
+	h, errH := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(height), "px"))
+	if errW != nil || errH != nil || w < 0 || h < 0 {
+		return 0
+	}
+	return w * h
+}
+
+// dominantImage return the largest <img> of the page by declared size, the
+// first one when no image declares it. Relative URL resolves against
+// `baseURL`. Nil is returned for pages without images.
+func dominantImage(root *html.Node, baseURL string, config *Config) *elementConent {
+	var (
+		best     *elementConent
+		bestArea = -1
+		visit    func(node *html.Node)
+	)
+
+	visit = func(node *html.Node) {
+		if node.Type == html.ElementNode && strings.ToLower(node.Data) == "img" {
+			area := imageArea(node)
+			if contents, err := parseIMG(node, config); err == nil && len(contents) > 0 &&
+				area > bestArea {
+				content := contents[0]
+				if content.dataType == dataURL {
+					fullURL, err := resolveURL(baseURL, content.data)
+					if err != nil {
+						return
+					}
+					content.data = fullURL
+				}
+				best, bestArea = content, area
+			}
+		}
+		for n := node.FirstChild; n != nil; n = n.NextSibling {
+			visit(n)
+		}
+	}
+	visit(root)
+
+	if best != nil {
+		// found by following the <a> element
+		best.contentType = aElement
+	}
+	return best
+}
+
+// followGalleryLinks return the dominant image of every page in `links`, in
+// the same order. Up to Config.Concurrency pages are fetched at once,
+// failures are passed to `report`.
+func (s *session) followGalleryLinks(ctx context.Context, links []string,
+	report func(DownloadEntry)) []*elementConent {
+	var (
+		found   = make([]*elementConent, len(links))
+		workers = s.config.workers()
+		sem     = semaphore.NewWeighted(int64(workers))
+		mu      sync.Mutex
+	)
+
+	for i, link := range links {
+		// fails only when context is cancelled
+		if err := sem.Acquire(ctx, 1); err != nil {
+			break
+		}
+		go func(i int, link string) {
+			defer sem.Release(1)
+
+			root, err := s.parseHTML(ctx, link)
+			if err != nil {
+				// linked resource is not necessarily a web page
+				if !errors.Is(err, errNotHTML) {
+					mu.Lock()
+					report(DownloadEntry{Error: err, SourceURL: link})
+					mu.Unlock()
+				}
+				return
+			}
+			found[i] = dominantImage(root, documentBase(root, link), s.config)
+		}(i, link)
+	}
+	// wait for pages being fetched
+	sem.Acquire(context.Background(), int64(workers))
+
+	contents := make([]*elementConent, 0, len(found))
+	for _, content := range found {
+		if content != nil {
+			contents = append(contents, content)
+		}
+	}
+	return contents
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// guard.go implements:
+//  - Blocking connections to loopback, private and link-local addresses.
+
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrPrivateAddress is returned for requests to loopback, private and
+// link-local addresses when Config.BlockPrivateNetworks is set.
+var ErrPrivateAddress = errors.New("connection to private network address is blocked")
+
+// private IPv4 and IPv6 ranges not covered by net.IP methods
+var privateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{
+		"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16",
+		// carrier-grade NAT
+		"100.64.0.0/10",
+		// unique local addresses
+		"fc00::/7",
+	} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// isPrivateIP report whether the address is not reachable from the internet.
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// privateNetworkGuard return dialer which resolves the host and refuses to
+// connect when any of its addresses is private. The checked addresses are
+// dialed in turn, so DNS answer can not change in between.
+func privateNetworkGuard(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range addrs {
+			if isPrivateIP(ip.IP) {
+				return nil, fmt.Errorf("%s (%s): %w", host, ip.IP, ErrPrivateAddress)
+			}
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("%s: no addresses", host)
+		}
+		for _, ip := range addrs {
+			var conn net.Conn
+			if conn, err = dial(ctx, network, net.JoinHostPort(ip.IP.String(), port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
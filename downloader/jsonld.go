@@ -0,0 +1,84 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// jsonld.go implements:
+//  - Extracting images from JSON-LD structured data: https://schema.org/image
+
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// jsonLDImageProperties are schema.org properties holding image URLs.
+var jsonLDImageProperties = map[string]bool{
+	"image":        true,
+	"thumbnailUrl": true,
+	"logo":         true,
+}
+
+// jsonLDImageURLs return URLs of image properties found anywhere in the
+// decoded JSON-LD `value`. An image is either URL, ImageObject with `url` or
+// `contentUrl`, or array of them.
+func jsonLDImageURLs(value interface{}, isImage bool) []string {
+	var urls []string
+	switch value := value.(type) {
+	case string:
+		if isImage {
+			urls = append(urls, value)
+		}
+	case []interface{}:
+		for _, item := range value {
+			urls = append(urls, jsonLDImageURLs(item, isImage)...)
+		}
+	case map[string]interface{}:
+		// keep the order stable, it defines the index of the image
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			item := value[key]
+			switch {
+			case jsonLDImageProperties[key]:
+				urls = append(urls, jsonLDImageURLs(item, true)...)
+			case isImage && (key == "url" || key == "contentUrl"):
+				urls = append(urls, jsonLDImageURLs(item, true)...)
+			default:
+				// images of nested entities, e.g. "brand" or "@graph"
+				urls = append(urls, jsonLDImageURLs(item, false)...)
+			}
+		}
+	}
+	return urls
+}
+
+// parseScript return images of JSON-LD <script> element.
+func parseScript(node *html.Node, config *Config) ([]*elementConent, error) {
+	type_, _ := getAttr(node, "type")
+	if strings.ToLower(strings.TrimSpace(type_)) != "application/ld+json" ||
+		node.FirstChild == nil {
+		return nil, nil
+	}
+
+	var data interface{}
+	if err := json.Unmarshal([]byte(node.FirstChild.Data), &data); err != nil {
+		config.logf("skipping invalid JSON-LD: %v", err)
+		return nil, fmt.Errorf("invalid JSON-LD: %w", err)
+	}
+
+	var contents []*elementConent
+	for _, url := range jsonLDImageURLs(data, false) {
+		if content, err := parseImageURL(strings.TrimSpace(url), jsonLDElement,
+			config); err == nil {
+			contents = append(contents, content)
+		}
+	}
+
+	return contents, nil
+}
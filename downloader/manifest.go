@@ -0,0 +1,131 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// manifest.go implements:
+//  - Writing JSON manifest of downloaded images.
+//  - Reading validators of the previous run for conditional requests.
+//  - Recording large inline images as Data URL.
+
+package downloader
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"os"
+)
+
+// ManifestFilename is the name of the manifest written to output directory.
+const ManifestFilename = "manifest.json"
+
+// Manifest is a record of images downloaded from a page.
+type Manifest struct {
+	Page string `json:"page"`
+	// Pages are the pages of DownloadImagesMulti, Page is empty then.
+	Pages  []string        `json:"pages,omitempty"`
+	Images []ManifestEntry `json:"images"`
+}
+
+// ManifestEntry describe a single image of the page.
+type ManifestEntry struct {
+	// URL is empty for images inlined into the page.
+	URL      string `json:"url,omitempty"`
+	Element  string `json:"element"`
+	Filename string `json:"filename,omitempty"`
+	Bytes    int64  `json:"bytes"`
+	Skipped  bool   `json:"skipped,omitempty"`
+	// Filtered is set for images discarded by filters, see
+	// DownloadEntry.Filtered.
+	Filtered bool   `json:"filtered,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// ETag and LastModified are validators of the image response, used by
+	// Config.ConditionalRequests.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// DataURL is the inline image which was not saved, see
+	// Config.MaxInlineBytes.
+	DataURL string `json:"data_url,omitempty"`
+}
+
+func newManifestEntry(content *elementConent, result downloadResult,
+	err error) ManifestEntry {
+	entry := ManifestEntry{
+		Element:      content.contentType.String(),
+		Filename:     result.filename,
+		Bytes:        result.written,
+		Skipped:      result.skipped,
+		Filtered:     result.filtered,
+		ETag:         result.etag,
+		LastModified: result.lastModified,
+		DataURL:      result.dataURL,
+	}
+	if len(result.dataURL) > 0 {
+		// the image is in the manifest itself
+		entry.Filename = ""
+	}
+	if content.dataType == dataURL {
+		entry.URL = content.data
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	return entry
+}
+
+// inlineDataURL return base64 Data URL of the inline image.
+func inlineDataURL(content *elementConent) string {
+	mediatype := mime.TypeByExtension("." + content.dataExt)
+	if len(mediatype) == 0 {
+		mediatype = "application/octet-stream"
+	}
+	return "data:" + mediatype + ";base64," +
+		base64.StdEncoding.EncodeToString([]byte(content.data))
+}
+
+// writeManifest save manifest as ManifestFilename into the sink.
+func writeManifest(sink Sink, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, err := sink.Create(ManifestFilename)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// previousValidators return manifest entries with validators by URL, read
+// from the manifest of the previous run. Only files which still exist are
+// returned, the manifest can be read from DirSink only.
+func previousValidators(sink Sink) map[string]ManifestEntry {
+	dir, ok := sink.(DirSink)
+	if !ok {
+		return nil
+	}
+	data, err := os.ReadFile(dir.Path(ManifestFilename))
+	if err != nil {
+		return nil
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil
+	}
+
+	validators := make(map[string]ManifestEntry)
+	for _, entry := range manifest.Images {
+		if len(entry.URL) == 0 || (len(entry.ETag) == 0 && len(entry.LastModified) == 0) {
+			continue
+		}
+		if _, err := os.Stat(entry.Filename); err == nil {
+			validators[entry.URL] = entry
+		}
+	}
+
+	return validators
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// options.go implements:
+//  - Downloader configured with functional options.
+
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Option changes the configuration of Downloader.
+type Option func(*Config)
+
+// WithConfig replace the whole configuration, options following it are
+// applied on top.
+func WithConfig(config Config) Option {
+	return func(c *Config) {
+		*c = config
+	}
+}
+
+// WithTimeout set Config.Timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.Timeout = timeout
+	}
+}
+
+// WithConcurrency set Config.Concurrency.
+func WithConcurrency(n int) Option {
+	return func(c *Config) {
+		c.Concurrency = n
+	}
+}
+
+// WithTLSVerify enable or disable verification of the server TLS
+// certificate.
+func WithTLSVerify(verify bool) Option {
+	return func(c *Config) {
+		c.InsecureSkipVerify = !verify
+	}
+}
+
+// WithUserAgent set Config.UserAgent.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Config) {
+		c.UserAgent = userAgent
+	}
+}
+
+// WithHeader add the header to every request.
+func WithHeader(key, value string) Option {
+	return func(c *Config) {
+		headers := make(map[string]string, len(c.Headers)+1)
+		// do not modify the map shared with the caller's Config
+		for k, v := range c.Headers {
+			headers[k] = v
+		}
+		headers[key] = value
+		c.Headers = headers
+	}
+}
+
+// WithTransport set Config.Transport.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *Config) {
+		c.Transport = transport
+	}
+}
+
+// WithHandlers set Config.Handlers, e.g. WithHandlers("img", "source").
+func WithHandlers(names ...string) Option {
+	return func(c *Config) {
+		c.Handlers = names
+	}
+}
+
+// Downloader download images of web pages with the same configuration.
+type Downloader struct {
+	config Config
+}
+
+// NewDownloader return Downloader with DefaultConfig changed by `opts`.
+func NewDownloader(opts ...Option) *Downloader {
+	config := DefaultConfig()
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return &Downloader{config: config}
+}
+
+// Config return the configuration of the downloader.
+func (d *Downloader) Config() Config {
+	return d.config
+}
+
+// Download start downloading images of the page at `pageURL` into `dir`. The
+// returned channel receives an entry per image, as with
+// DownloadImagesContext, and is closed when downloads are finished. Error is
+// returned when `pageURL` is not an absolute HTTP(S) URL, see CheckPageURL.
+func (d *Downloader) Download(ctx context.Context, pageURL string,
+	dir string) (<-chan DownloadEntry, error) {
+	if err := CheckPageURL(pageURL); err != nil {
+		return nil, err
+	}
+
+	feedback := make(chan DownloadEntry)
+	go DownloadImagesContext(ctx, pageURL, dir, d.config, feedback)
+
+	return feedback, nil
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// robots.go implements:
+//  - Parsing robots.txt rules for a user agent: https://www.rfc-editor.org/rfc/rfc9309
+//  - Checking URLs against rules of their host.
+
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// user agent sent by net/http when Config.UserAgent is not set
+const defaultRobotsAgent = "Go-http-client"
+
+// ErrDisallowedByRobots is returned for URLs disallowed by robots.txt.
+var ErrDisallowedByRobots = errors.New("disallowed by robots.txt")
+
+type robotsRule struct {
+	allow   bool
+	path    string
+	pattern *regexp.Regexp
+}
+
+type robotsRules []robotsRule
+
+// robotsCache hold rules of a single host, fetched once.
+type robotsCache struct {
+	once  sync.Once
+	rules robotsRules
+}
+
+// robotsPattern convert rule path with "*" and "$" wildcards into regexp.
+func robotsPattern(rulePath string) *regexp.Regexp {
+	anchored := strings.HasSuffix(rulePath, "$")
+	pattern := regexp.QuoteMeta(strings.TrimSuffix(rulePath, "$"))
+	pattern = "^" + strings.ReplaceAll(pattern, `\*`, ".*")
+	if anchored {
+		pattern += "$"
+	}
+	return regexp.MustCompile(pattern)
+}
+
+// robotsAgent return the product token of user agent, e.g. "imagedown" for
+// "imagedown/1.0 (+https://example.com)".
+func robotsAgent(userAgent string) string {
+	fields := strings.Fields(userAgent)
+	if len(fields) == 0 {
+		return defaultRobotsAgent
+	}
+	if product := strings.SplitN(fields[0], "/", 2)[0]; len(product) > 0 {
+		return product
+	}
+	return defaultRobotsAgent
+}
+
+// parseRobots return rules of the groups whose product token equals `agent`,
+// ignoring case, or of the "*" groups when there are no such groups.
+func parseRobots(r io.Reader, agent string) robotsRules {
+	var (
+		matched, wildcard robotsRules
+		hasMatched        bool
+		// agents of the current group
+		groupAgents []string
+		inRules     bool
+	)
+	agent = strings.ToLower(agent)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		comp := strings.SplitN(line, ":", 2)
+		if len(comp) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(comp[0]))
+		value := strings.TrimSpace(comp[1])
+
+		switch key {
+		case "user-agent":
+			if inRules {
+				// user-agent after rules starts a new group
+				groupAgents, inRules = nil, false
+			}
+			// empty user-agent line names no agent
+			if product := strings.SplitN(value, "/", 2)[0]; len(product) > 0 {
+				groupAgents = append(groupAgents, strings.ToLower(product))
+			}
+		case "allow", "disallow":
+			inRules = true
+			// empty Disallow allows everything
+			if len(value) == 0 {
+				continue
+			}
+			rule := robotsRule{key == "allow", value, robotsPattern(value)}
+			for _, groupAgent := range groupAgents {
+				if groupAgent == "*" {
+					wildcard = append(wildcard, rule)
+				} else if groupAgent == agent {
+					matched = append(matched, rule)
+					hasMatched = true
+				}
+			}
+		}
+	}
+
+	if hasMatched {
+		return matched
+	}
+	return wildcard
+}
+
+// allowed return whether the path is allowed, the longest matching rule wins
+// and Allow wins over Disallow of the same length.
+func (rules robotsRules) allowed(path string) bool {
+	allowed, length := true, -1
+	for _, rule := range rules {
+		if !rule.pattern.MatchString(path) {
+			continue
+		}
+		if len(rule.path) > length || (len(rule.path) == length && rule.allow) {
+			allowed, length = rule.allow, len(rule.path)
+		}
+	}
+
+	return allowed
+}
+
+// robotsAllowed return whether robots.txt of the URL host allows fetching it.
+// Missing or unavailable robots.txt allows everything.
+func (s *session) robotsAllowed(ctx context.Context, rawURL string) bool {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || !parsedURL.IsAbs() {
+		return true
+	}
+	origin := parsedURL.Scheme + "://" + parsedURL.Host
+
+	s.mu.Lock()
+	cache, found := s.robots[origin]
+	if !found {
+		cache = &robotsCache{}
+		s.robots[origin] = cache
+	}
+	s.mu.Unlock()
+
+	cache.once.Do(func() {
+		resp, err := s.fetch(ctx, origin+"/robots.txt", nil)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			cache.rules = parseRobots(resp.Body, robotsAgent(s.config.UserAgent))
+		}
+	})
+
+	path := parsedURL.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+	if len(parsedURL.RawQuery) > 0 {
+		path += "?" + parsedURL.RawQuery
+	}
+	return cache.rules.allowed(path)
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// sink.go implements:
+//  - Abstraction of the storage downloaded images are written to.
+//  - Storing images in the local directory.
+//  - Appending to partially written files.
+
+package downloader
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Sink stores downloaded images. Names are slash separated paths relative to
+// the sink, e.g. "photo.png" or "assets/photo.png".
+type Sink interface {
+	Create(name string) (io.WriteCloser, error)
+}
+
+// ExistSink is implemented by sinks able to report already stored files. It
+// is used by Config.OnExisting and to avoid overwriting files, sinks without
+// it are considered empty.
+type ExistSink interface {
+	Sink
+	Exists(name string) bool
+}
+
+// ResumeSink is implemented by sinks able to continue partially written
+// files, it is used by Config.Resume.
+type ResumeSink interface {
+	Sink
+	// Size return the size of the stored file.
+	Size(name string) (int64, bool)
+	// Append open the stored file for writing at its end.
+	Append(name string) (io.WriteCloser, error)
+}
+
+// Aborter is implemented by files of sinks able to discard a partially
+// written file, e.g. when the connection drops. Abort is called instead of
+// Close then.
+type Aborter interface {
+	Abort()
+}
+
+// DirSink stores images in the local directory.
+type DirSink string
+
+// Create create or truncate the file in the directory, creating missing
+// subdirectories.
+func (d DirSink) Create(name string) (io.WriteCloser, error) {
+	filename := d.Path(name)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(filename)
+}
+
+// Exists return whether the file exists in the directory. Empty files, e.g.
+// left by a failed download, are considered missing and get replaced.
+func (d DirSink) Exists(name string) bool {
+	info, err := os.Stat(d.Path(name))
+	return err == nil && (!info.Mode().IsRegular() || info.Size() > 0)
+}
+
+// Size return the size of the file in the directory.
+func (d DirSink) Size(name string) (int64, bool) {
+	info, err := os.Stat(d.Path(name))
+	if err != nil || !info.Mode().IsRegular() {
+		return 0, false
+	}
+	return info.Size(), true
+}
+
+// Append open the existing file in the directory for appending.
+func (d DirSink) Append(name string) (io.WriteCloser, error) {
+	return os.OpenFile(d.Path(name), os.O_WRONLY|os.O_APPEND, 0)
+}
+
+// rename move the file within the directory, creating missing
+// subdirectories.
+func (d DirSink) rename(oldName, newName string) error {
+	filename := d.Path(newName)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return os.Rename(d.Path(oldName), filename)
+}
+
+// remove delete the file from the directory.
+func (d DirSink) remove(name string) error {
+	return os.Remove(d.Path(name))
+}
+
+// Path return the path of the file in the directory.
+func (d DirSink) Path(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(name))
+}
+
+// name is the reverse of Path, false if the path is outside the directory.
+func (d DirSink) name(path string) (string, bool) {
+	rel, err := filepath.Rel(string(d), path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
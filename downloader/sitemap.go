@@ -0,0 +1,85 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// sitemap.go implements:
+//  - Listing pages of a sitemap, including sitemap index files:
+//    https://www.sitemaps.org/protocol.html
+
+package downloader
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// sitemapDocument is either <urlset> or <sitemapindex>.
+type sitemapDocument struct {
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+// fetchSitemap return the parsed sitemap, each sitemap is limited by
+// Config.MaxPageBytes.
+func (s *session) fetchSitemap(ctx context.Context, sitemapURL string) (*sitemapDocument, error) {
+	resp, err := s.get(ctx, sitemapURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPStatusError(sitemapURL, resp)
+	}
+	var document sitemapDocument
+	if err := xml.NewDecoder(s.limitPage(resp.Body)).Decode(&document); err != nil {
+		return nil, fmt.Errorf("%s: %w", sitemapURL, err)
+	}
+
+	return &document, nil
+}
+
+// sitemapPages return pages listed by the sitemap and sitemaps it indexes.
+// Failure of the sitemap at `sitemapURL` is returned, failures of indexed
+// sitemaps are passed to `report`.
+func (s *session) sitemapPages(ctx context.Context, sitemapURL string,
+	report func(DownloadEntry)) ([]string, error) {
+	var (
+		pages   []string
+		queue   = []string{sitemapURL}
+		visited = map[string]bool{sitemapURL: true}
+	)
+
+	for len(queue) > 0 && ctx.Err() == nil {
+		current := queue[0]
+		queue = queue[1:]
+
+		document, err := s.fetchSitemap(ctx, current)
+		if err != nil {
+			if current == sitemapURL {
+				return nil, err
+			}
+			report(DownloadEntry{Error: err, SourceURL: current})
+			continue
+		}
+		for _, page := range document.URLs {
+			if link, err := resolveURL(current, strings.TrimSpace(page.Loc)); err == nil {
+				pages = append(pages, link)
+			}
+		}
+		for _, sitemap := range document.Sitemaps {
+			link, err := resolveURL(current, strings.TrimSpace(sitemap.Loc))
+			if err == nil && !visited[link] {
+				visited[link] = true
+				queue = append(queue, link)
+			}
+		}
+	}
+
+	return pages, ctx.Err()
+}
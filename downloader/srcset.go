@@ -0,0 +1,86 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// srcset.go implements:
+//  - Parsing of the `srcset` attribute into list of image candidates:
+//    https://html.spec.whatwg.org/multipage/images.html#srcset-attributes
+//  - Selecting the highest resolution candidate.
+
+package downloader
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type srcsetCandidate struct {
+	url     string
+	width   int
+	density float64
+}
+
+// parseSrcset split `srcset` into candidates, descriptors other than width
+// and pixel density are ignored.
+func parseSrcset(srcset string) []srcsetCandidate {
+	var candidates []srcsetCandidate
+
+	for len(srcset) > 0 {
+		srcset = strings.TrimLeftFunc(srcset, func(r rune) bool {
+			return unicode.IsSpace(r) || r == ','
+		})
+		if len(srcset) == 0 {
+			break
+		}
+		// URL is a run of non-whitespace characters
+		end := strings.IndexFunc(srcset, unicode.IsSpace)
+		if end < 0 {
+			end = len(srcset)
+		}
+		candidate := srcsetCandidate{url: srcset[:end], density: 1}
+		srcset = srcset[end:]
+
+		if strings.HasSuffix(candidate.url, ",") {
+			// no descriptors for this candidate
+			candidate.url = strings.TrimRight(candidate.url, ",")
+		} else {
+			var descriptors string
+			if end = strings.IndexByte(srcset, ','); end < 0 {
+				descriptors, srcset = srcset, ""
+			} else {
+				descriptors, srcset = srcset[:end], srcset[end+1:]
+			}
+			for _, descriptor := range strings.Fields(descriptors) {
+				value := descriptor[:len(descriptor)-1]
+				switch descriptor[len(descriptor)-1] {
+				case 'w':
+					if width, err := strconv.Atoi(value); err == nil {
+						candidate.width = width
+					}
+				case 'x':
+					if density, err := strconv.ParseFloat(value, 64); err == nil {
+						candidate.density = density
+					}
+				}
+			}
+		}
+		if len(candidate.url) > 0 {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	return candidates
+}
+
+// bestSrcsetCandidate return the candidate with the highest resolution, width
+// descriptors take precedence over pixel density.
+func bestSrcsetCandidate(candidates []srcsetCandidate) srcsetCandidate {
+	best := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if candidate.width > best.width ||
+			(candidate.width == best.width && candidate.density > best.density) {
+			best = candidate
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// stylesheet.go implements:
+//  - Collecting stylesheets linked by the page or imported by CSS.
+//  - Extracting images referenced by external stylesheets.
+
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// maximum nesting of @import rules followed from a stylesheet
+const maxImportDepth = 8
+
+type stylesheet struct {
+	url   string
+	depth int
+}
+
+// stylesheetLinks return absolute URLs of stylesheets linked by <link
+// rel="stylesheet"> elements and imported by <style> elements.
+func stylesheetLinks(root *html.Node, pageURL string) []string {
+	var (
+		links []string
+		visit func(node *html.Node)
+	)
+
+	addLink := func(href string) {
+		if link, err := resolveURL(pageURL, strings.TrimSpace(href)); err == nil {
+			links = append(links, link)
+		}
+	}
+	visit = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			switch strings.ToLower(node.Data) {
+			case "link":
+				rel, _ := getAttr(node, "rel")
+				href, exist := getAttr(node, "href")
+				for _, relation := range strings.Fields(strings.ToLower(rel)) {
+					if relation == "stylesheet" && exist && len(href) > 0 && !IsDataURL(href) {
+						addLink(href)
+						break
+					}
+				}
+			case "style":
+				for n := node.FirstChild; n != nil; n = n.NextSibling {
+					if n.Type == html.TextNode {
+						imports, _ := parseCSSImports(n.Data)
+						for _, href := range imports {
+							addLink(href)
+						}
+					}
+				}
+			}
+		}
+		for n := node.FirstChild; n != nil; n = n.NextSibling {
+			visit(n)
+		}
+	}
+	visit(root)
+
+	return links
+}
+
+// fetchStylesheet return text of the stylesheet, limited by
+// Config.MaxPageBytes.
+func (s *session) fetchStylesheet(ctx context.Context, url string) (string, error) {
+	resp, err := s.get(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", newHTTPStatusError(url, resp)
+	}
+	css, err := io.ReadAll(s.limitPage(resp.Body))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", url, err)
+	}
+
+	return string(css), nil
+}
+
+// collectStylesheets return images referenced by the stylesheets and,
+// up to maxImportDepth levels, by stylesheets they import. URLs are resolved
+// against the stylesheet URL. Stylesheets already in `visited` are skipped,
+// failures are passed to `report`.
+func (s *session) collectStylesheets(ctx context.Context, urls []string,
+	visited map[string]bool, report func(DownloadEntry)) []*elementConent {
+	var (
+		contents []*elementConent
+		queue    []stylesheet
+	)
+	for _, url := range urls {
+		queue = append(queue, stylesheet{url, 0})
+	}
+
+	for len(queue) > 0 && ctx.Err() == nil {
+		sheet := queue[0]
+		queue = queue[1:]
+		if visited[sheet.url] {
+			continue
+		}
+		visited[sheet.url] = true
+
+		css, err := s.fetchStylesheet(ctx, sheet.url)
+		if err != nil {
+			report(DownloadEntry{Error: err, SourceURL: sheet.url})
+			continue
+		}
+
+		imports, rules := parseCSSImports(css)
+		for _, href := range imports {
+			if sheet.depth >= maxImportDepth {
+				s.config.logf("Skipping stylesheet imported too deep: %s", href)
+				break
+			}
+			if link, err := resolveURL(sheet.url, href); err == nil {
+				queue = append(queue, stylesheet{link, sheet.depth + 1})
+			}
+		}
+		for _, url := range parseCSSURLs(rules) {
+			content, err := parseCSSImageURL(url, stylesheetElement, s.config)
+			if err != nil {
+				report(DownloadEntry{Error: err, ElementType: stylesheetElement.String()})
+				continue
+			} else if content == nil {
+				continue
+			}
+			if content.dataType == dataURL {
+				if fullURL, err := resolveURL(sheet.url, content.data); err == nil {
+					content.data = fullURL
+				}
+			}
+			contents = append(contents, content)
+		}
+	}
+
+	return contents
+}
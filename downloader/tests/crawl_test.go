@@ -0,0 +1,250 @@
+package downloader
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"onethinglab.com/imagedown/downloader"
+)
+
+func TestCrawlDepth(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	var externalRequests int32
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&externalRequests, 1)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><img src="/external.png"></body></html>`))
+	}))
+	defer external.Close()
+
+	pages := map[string]string{
+		"/": `<html><body><img src="/first.png">
+			<a href="/second">next</a><a href="` + external.URL + `/">external</a>
+			<a href="/file.pdf">document</a><a href="/first.png">image</a>
+		</body></html>`,
+		// links back to the first page
+		"/second": `<html><body><img src="/second.png"><a href="/#top">home</a>
+			<a href="/third">next</a>
+		</body></html>`,
+		"/third": `<html><body><img src="/third.png"></body></html>`,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if page, found := pages[r.URL.Path]; found {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(page))
+			return
+		}
+		if r.URL.Path == "/file.pdf" {
+			w.Header().Set("Content-Type", "application/pdf")
+			return
+		}
+		w.Write(png)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	type testCase struct {
+		depth int
+		files []string
+	}
+
+	var testCases = []testCase{
+		{0, []string{"first.png"}},
+		{1, []string{"first.png", "second.png"}},
+		{2, []string{"first.png", "second.png", "third.png"}},
+	}
+
+	for _, test := range testCases {
+		config := downloader.DefaultConfig()
+		config.Depth = test.depth
+		feedback := make(chan downloader.DownloadEntry)
+		go downloader.DownloadImagesContext(context.Background(), server.URL, t.TempDir(),
+			config, feedback)
+
+		names := downloadedNames(t, collect(feedback))
+		if !cmp.Equal(names, test.files) {
+			t.Errorf("depth %d: downloaded %v, want %v", test.depth, names, test.files)
+		}
+	}
+	if externalRequests != 0 {
+		t.Errorf("external page requested %d times", externalRequests)
+	}
+}
+
+func TestFollowImageLinks(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	var aboutRequests int32
+	pages := map[string]string{
+		"/": `<html><body>
+			<a href="/photos/1.html"><img src="/thumbs/1.png"></a>
+			<a href="/photos/2.html"><span><img src="/thumbs/2.png"></span></a>
+			<a href="/about.html">about</a>
+		</body></html>`,
+		"/photos/1.html": `<html><body>
+			<img src="/logo.png" width="50" height="50">
+			<img src="/full/1.png" width="1200" height="800">
+		</body></html>`,
+		// images without declared size, the first one is taken
+		"/photos/2.html": `<html><body><img src="../full/2.png"><img src="/ad.png"></body></html>`,
+		"/about.html":    `<html><body><img src="/team.png"></body></html>`,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/about.html" {
+			atomic.AddInt32(&aboutRequests, 1)
+		}
+		if page, found := pages[r.URL.Path]; found {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(page))
+			return
+		}
+		w.Write(png)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := downloader.DefaultConfig()
+	config.ListOnly = true
+	config.FollowImageLinks = true
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesContext(context.Background(), server.URL, t.TempDir(),
+		config, feedback)
+
+	var urls []string
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			t.Errorf("unexpected error: %v", entry.Error)
+		}
+		urls = append(urls, entry.SourceURL)
+	}
+	expected := []string{
+		server.URL + "/thumbs/1.png", server.URL + "/thumbs/2.png",
+		server.URL + "/full/1.png", server.URL + "/full/2.png",
+	}
+	if diff := cmp.Diff(expected, urls); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+	if aboutRequests != 0 {
+		t.Errorf("page without thumbnail requested %d times", aboutRequests)
+	}
+}
+
+func TestDownloadImagesMulti(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	var cdnRequests int32
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&cdnRequests, 1)
+		w.Write(png)
+	}))
+	defer cdn.Close()
+
+	pages := map[string]string{
+		"/first":  `<html><body><img src="/a.png"><img src="` + cdn.URL + `/shared.png"></body></html>`,
+		"/second": `<html><body><img src="` + cdn.URL + `/shared.png"><img src="/b.png"></body></html>`,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if page, found := pages[r.URL.Path]; found {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(page))
+			return
+		}
+		if r.URL.Path == "/a.png" || r.URL.Path == "/b.png" {
+			w.Write(png)
+			return
+		}
+		http.NotFound(w, r)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dir := t.TempDir()
+	config := downloader.DefaultConfig()
+	config.WriteManifest = true
+	pageURLs := []string{server.URL + "/first", server.URL + "/second", server.URL + "/missing"}
+	feedback := make(chan downloader.DownloadEntry)
+	var summary downloader.Summary
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// invalid URLs are reported without failing the other pages
+		summary, err = downloader.DownloadImagesMulti(context.Background(),
+			append([]string{"", "ftp://example.com/page"}, pageURLs...), dir, config, feedback)
+	}()
+
+	var names []string
+	var failed []string
+	for entry := range feedback {
+		if entry.Error != nil {
+			failed = append(failed, entry.SourceURL)
+			continue
+		}
+		names = append(names, filepath.Base(entry.Filename))
+	}
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(names)
+	if expected := []string{"a.png", "b.png", "shared.png"}; !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+	expected := []string{"", "ftp://example.com/page", server.URL + "/missing"}
+	if !cmp.Equal(failed, expected) {
+		t.Errorf("failed pages %v, want %v", failed, expected)
+	}
+	if summary.Downloaded != 3 {
+		t.Errorf("expected 3 downloads, got %+v", summary)
+	}
+	// robots.txt and the shared image
+	if n := atomic.LoadInt32(&cdnRequests); n != 2 {
+		t.Errorf("expected 2 requests to the CDN, got %d", n)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, downloader.ManifestFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest downloader.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	if !cmp.Equal(manifest.Pages, pageURLs) {
+		t.Errorf("manifest pages %v, want %v", manifest.Pages, pageURLs)
+	}
+}
+
+func TestCheckPageURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://example.com/gallery", true},
+		{"http://127.0.0.1:8080", true},
+		{"", false},
+		{"example.com", false},
+		{"/relative", false},
+		{"ftp://example.com/", false},
+		{"http://", false},
+		{"http://example.com/%zz", false},
+	}
+	for _, test := range tests {
+		err := downloader.CheckPageURL(test.url)
+		if (err == nil) != test.valid || err != nil && !errors.Is(err, downloader.ErrInvalidPageURL) {
+			t.Errorf("CheckPageURL(%q) = %v, want valid %v", test.url, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package downloader
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"onethinglab.com/imagedown/downloader"
+)
+
+func TestInlineStyleImages(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	files := map[string][]byte{
+		"/bg.png": png, "/hero.jpg": png, "/one.gif": png, "/two.png": png,
+		"/three.png": png,
+	}
+
+	type testCase struct {
+		name  string
+		style string
+		files []string
+	}
+
+	var testCases = []testCase{
+		{"background", `background: #fff url(bg.png) no-repeat`, []string{"bg.png"}},
+		{"background-image", `background-image: url( &quot;hero.jpg&quot; )`, []string{"hero.jpg"}},
+		{"multiple", `background-image: url('one.gif'), url(&quot;two.png&quot;), URL(three.png);
+			font-family: serif`, []string{"one.gif", "three.png", "two.png"}},
+		{"non-image", `background: url(font.woff2)`, []string{}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			page := `<html><body><div style="` + test.style + `"><span>text</span></div></body></html>`
+			server := newPageServer(t, page, files)
+			feedback := make(chan downloader.DownloadEntry)
+			go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+			names := downloadedNames(t, collect(feedback))
+			if !cmp.Equal(names, test.files) {
+				t.Errorf("downloaded %v, want %v", names, test.files)
+			}
+		})
+	}
+}
+
+func TestStyleElementImages(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><head><style>
+		@import url("theme.css");
+		@import 'print.css' print;
+		body { background: url(bg.png) no-repeat; }
+		.hero { background-image: url("hero.jpg"), url('overlay.gif'); }
+		ul { list-style-image: url(bullet.svg); }
+		@font-face { font-family: Sans; src: url(sans.woff2) format("woff2"); }
+	</style></head><body></body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/bg.png": png, "/hero.jpg": png, "/overlay.gif": png, "/bullet.svg": png,
+		"/theme.css": png, "/print.css": png, "/sans.woff2": png,
+	})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	entries := collect(feedback)
+	names := downloadedNames(t, entries)
+	expected := []string{"bg.png", "bullet.svg", "hero.jpg", "overlay.gif"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+	for _, entry := range entries {
+		if entry.ElementType != "<style>" {
+			t.Errorf("unexpected element type %q of %s", entry.ElementType, entry.SourceURL)
+		}
+	}
+}
+
+func TestExternalStylesheets(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><head>
+		<link rel="stylesheet" href="/css/main.css">
+		<style>@import "/extra.css";</style>
+	</head><body></body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		// imports form a cycle
+		"/css/main.css": []byte(`@import url(theme.css);
+			body { background: url(../img/bg.png) } .icon { background: url("icons.svg") }`),
+		"/css/theme.css": []byte(`@import "main.css"; .hero { background: url(hero.jpg) }`),
+		"/extra.css":     []byte(`.extra { background: url(extra.gif) }`),
+		"/img/bg.png":    png, "/css/icons.svg": png, "/css/hero.jpg": png, "/extra.gif": png,
+	})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+	if names := downloadedNames(t, collect(feedback)); len(names) != 0 {
+		t.Errorf("stylesheets are followed by default: %v", names)
+	}
+
+	config := downloader.DefaultConfig()
+	config.FollowStylesheets = true
+	feedback = make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+	names := downloadedNames(t, collect(feedback))
+	expected := []string{"bg.png", "extra.gif", "hero.jpg", "icons.svg"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+}
+
+func TestStylesheetMaxPageBytes(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><head><link rel="stylesheet" href="/large.css"></head><body></body></html>`
+	css := `.hero { background: url(hero.png) }` + strings.Repeat("/* filler */", 1000)
+	server := newPageServer(t, page, map[string][]byte{
+		"/large.css": []byte(css), "/hero.png": png,
+	})
+
+	config := downloader.DefaultConfig()
+	config.FollowStylesheets = true
+	config.MaxPageBytes = int64(len(css)) - 1
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+	entries := collect(feedback)
+	if len(entries) != 1 || entries[0].SourceURL != server.URL+"/large.css" ||
+		!errors.Is(entries[0].Error, downloader.ErrPageTooLarge) {
+		t.Errorf("expected the stylesheet to exceed the limit, got %+v", entries)
+	}
+}
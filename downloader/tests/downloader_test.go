@@ -0,0 +1,2589 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/go-cmp/cmp"
+	"onethinglab.com/imagedown/downloader"
+)
+
+// 1x1 transparent PNG.
+const pixelPNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+
+// newPageServer serves `page` as HTML on "/" and `files` on their paths, typed
+// by image extension or sniffed.
+func newPageServer(t *testing.T, page string, files map[string][]byte) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(page))
+			return
+		}
+		data, found := files[r.URL.Path]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		if mediatype := mime.TypeByExtension(path.Ext(r.URL.Path)); strings.HasPrefix(mediatype, "image/") {
+			w.Header().Set("Content-Type", mediatype)
+		}
+		w.Write(data)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// collect drains the feedback channel.
+func collect(feedback chan downloader.DownloadEntry) []downloader.DownloadEntry {
+	entries := make([]downloader.DownloadEntry, 0)
+	for entry := range feedback {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestDownloadBase64DataURL(t *testing.T) {
+	page := `<html><body><img src="data:image/png;base64,` + pixelPNG + `"></body></html>`
+	server := newPageServer(t, page, nil)
+	dir := t.TempDir()
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, dir, feedback)
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			t.Fatalf("unexpected error: %v", entry.Error)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.png"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one png file, got %v (%v)", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	if !bytes.Equal(data, expected) {
+		t.Errorf("saved image does not match decoded data URL")
+	}
+}
+
+func TestDownloadSVGDataURL(t *testing.T) {
+	const svg = `<svg xmlns="http://www.w3.org/2000/svg"><circle r="1" fill="#000"/></svg>`
+	page := `<html><body>
+		<img src="data:image/svg+xml,%3Csvg%20xmlns=%22http://www.w3.org/2000/svg%22%3E` +
+		`%3Ccircle%20r=%221%22%20fill=%22%23000%22/%3E%3C/svg%3E">
+	</body></html>`
+	server := newPageServer(t, page, nil)
+	dir := t.TempDir()
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, dir, feedback)
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			t.Fatalf("unexpected error: %v", entry.Error)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.svg"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one svg file, got %v (%v)", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(svg, string(data)); diff != "" {
+		t.Errorf("saved image does not match unescaped data URL (-want +got):\n%s", diff)
+	}
+}
+
+func TestSVGReferences(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body>
+		<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">
+			<defs><circle id="dot" r="1"/></defs>
+			<image href="/images/photo.png" width="1" height="1"/>
+			<image xlink:href="/images/legacy.png" width="1" height="1"/>
+			<use href="#dot"/>
+		</svg>
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/images/photo.png": png, "/images/legacy.png": png,
+	})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	sources := make(map[string]string)
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			t.Errorf("unexpected error: %v", entry.Error)
+		}
+		sources[entry.SourceURL] = entry.ElementType
+	}
+	expected := map[string]string{
+		"": "<svg>", server.URL + "/images/photo.png": "<svg>",
+		server.URL + "/images/legacy.png": "<svg>",
+	}
+	if diff := cmp.Diff(expected, sources); diff != "" {
+		t.Errorf("unexpected entries (-want +got):\n%s", diff)
+	}
+}
+
+func TestSanitizedSVG(t *testing.T) {
+	page := `<html><body>
+		<svg width="1" height="1"> </svg>
+		<svg width="2" height="2"><script>alert(1)</script></svg>
+		<svg width="3" height="3" onload="alert(1)"><script>alert(1)</script><rect width="3" height="3"/></svg>
+	</body></html>`
+	server := newPageServer(t, page, nil)
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	entries := collect(feedback)
+	if len(entries) != 1 || entries[0].Error != nil {
+		t.Fatalf("expected a single saved svg, got %+v", entries)
+	}
+	data, err := os.ReadFile(entries[0].Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<svg width="3" height="3"><rect width="3" height="3"></rect></svg>`
+	if diff := cmp.Diff(expected, string(data)); diff != "" {
+		t.Errorf("unexpected svg (-want +got):\n%s", diff)
+	}
+}
+
+func TestDownloadedFilenameExists(t *testing.T) {
+	page := `<html><body>
+		<svg width="10" height="10"><rect width="10" height="10"/></svg>
+		<img src="/pixel.png">
+	</body></html>`
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	server := newPageServer(t, page, map[string][]byte{"/pixel.png": png})
+	dir := t.TempDir()
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, dir, feedback)
+	entries := collect(feedback)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Error != nil {
+			t.Fatalf("unexpected error: %v", entry.Error)
+		}
+		if _, err := os.Stat(entry.Filename); err != nil {
+			t.Errorf("downloaded file %s does not exist: %v", entry.Filename, err)
+		}
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	page := `<html><body><img src="` + slow.URL + `/slow.png"></body></html>`
+	server := newPageServer(t, page, nil)
+
+	config := downloader.DefaultConfig()
+	config.Timeout = 100 * time.Millisecond
+	config.MaxAttempts = 1
+	feedback := make(chan downloader.DownloadEntry)
+	start := time.Now()
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+	entries := collect(feedback)
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("download took %v, timeout not applied", elapsed)
+	}
+	if len(entries) != 1 || entries[0].Error == nil {
+		t.Errorf("expected a single timeout error, got %+v", entries)
+	}
+}
+
+func TestTLSVerification(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body><img src="/pixel.png"></body></html>`))
+			return
+		}
+		w.Write(png)
+	}))
+	defer server.Close()
+
+	run := func(insecure bool) []downloader.DownloadEntry {
+		config := downloader.DefaultConfig()
+		config.InsecureSkipVerify = insecure
+		feedback := make(chan downloader.DownloadEntry)
+		go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+		return collect(feedback)
+	}
+
+	entries := run(false)
+	if len(entries) != 1 || entries[0].Error == nil ||
+		!strings.Contains(entries[0].Error.Error(), "certificate") {
+		t.Errorf("expected certificate error, got %+v", entries)
+	}
+
+	entries = run(true)
+	if len(entries) != 1 || entries[0].Error != nil {
+		t.Errorf("expected successful download, got %+v", entries)
+	}
+}
+
+// downloadedNames return sorted base names of successfully downloaded files.
+func downloadedNames(t *testing.T, entries []downloader.DownloadEntry) []string {
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Error != nil {
+			t.Errorf("unexpected error: %v", entry.Error)
+			continue
+		}
+		names = append(names, filepath.Base(entry.Filename))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSrcset(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	files := map[string][]byte{
+		"/small.png": png, "/medium.png": png, "/large.png": png,
+		"/a.png": png, "/b.png": png, "/c.png": png,
+	}
+
+	type testCase struct {
+		name  string
+		img   string
+		all   bool
+		files []string
+	}
+
+	var testCases = []testCase{
+		{"highest width",
+			`<img src="small.png" srcset="  medium.png 800w,
+				large.png   1080w , small.png 480w">`,
+			false, []string{"large.png"}},
+		{"highest density", `<img src="a.png" srcset="b.png 2x,c.png 1.5x">`,
+			false, []string{"b.png"}},
+		{"all candidates", `<img srcset="a.png, b.png 2x,c.png 640w ,">`,
+			true, []string{"a.png", "b.png", "c.png"}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			server := newPageServer(t, "<html><body>"+test.img+"</body></html>", files)
+			config := downloader.DefaultConfig()
+			config.AllSrcsetCandidates = test.all
+			feedback := make(chan downloader.DownloadEntry)
+			go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+			names := downloadedNames(t, collect(feedback))
+			if !cmp.Equal(names, test.files) {
+				t.Errorf("downloaded %v, want %v", names, test.files)
+			}
+		})
+	}
+}
+
+func TestPicture(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body>
+		<picture>
+			<source srcset="hero.webp" type="image/webp">
+			<source media="(min-width: 800px)" srcset="hero-large.jpg 2x, hero.jpg 1x">
+			<source srcset="hero.mp4" type="video/mp4">
+			<img src="hero-fallback.jpg" alt="Hero">
+		</picture>
+		<video><source src="movie.mp4" type="video/mp4"></video>
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/hero.webp": png, "/hero-large.jpg": png, "/hero.jpg": png,
+		"/hero-fallback.jpg": png, "/hero.mp4": png, "/movie.mp4": png,
+	})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	names := downloadedNames(t, collect(feedback))
+	expected := []string{"hero-fallback.jpg", "hero-large.jpg", "hero.webp"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+}
+
+func TestVideoPoster(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body>
+		<video poster="thumb.jpg"><source src="movie.mp4" type="video/mp4"></video>
+		<video src="clip.mp4"></video>
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/thumb.jpg": png, "/movie.mp4": png})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	entries := collect(feedback)
+	names := downloadedNames(t, entries)
+	expected := []string{"thumb.jpg"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+	if len(entries) > 0 && entries[0].ElementType != "<video>" {
+		t.Errorf("unexpected element type %q", entries[0].ElementType)
+	}
+}
+
+func TestMetaImages(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><head>
+		<meta property="og:image" content="/og.png">
+		<meta property="og:image:url" content="/og-url.jpg">
+		<meta name="twitter:image" content="/card">
+		<meta property="og:title" content="title.png">
+	</head><body></body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/og.png": png, "/og-url.jpg": png, "/card": png, "/title.png": png,
+	})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	names := downloadedNames(t, collect(feedback))
+	expected := []string{"card.png", "og-url.jpg", "og.png"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+}
+
+func TestJSONLDImages(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><head>
+		<script type="application/ld+json">
+		{
+			"@context": "https://schema.org/",
+			"@type": "Product",
+			"name": "Executive Anvil",
+			"image": [
+				"/photos/1x1/photo.jpg",
+				"/photos/4x3/photo.jpg"
+			],
+			"description": "Sleeker than ACME's Classic Anvil.",
+			"sku": "0446310786",
+			"brand": {
+				"@type": "Brand",
+				"name": "ACME",
+				"logo": {"@type": "ImageObject", "url": "/logo.png", "width": 100}
+			},
+			"review": {
+				"@type": "Review",
+				"author": {"@type": "Person", "name": "Fred Benson", "image": "/fred"},
+				"url": "/reviews/1"
+			},
+			"offers": {"@type": "Offer", "url": "/anvil", "price": "119.99"}
+		}
+		</script>
+		<script type="application/ld+json">{"@type": "VideoObject", "thumbnailUrl": ["/thumb.gif"]}</script>
+		<script type="application/ld+json">{not json</script>
+		<script>var image = "/script.png";</script>
+	</head><body></body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/photos/1x1/photo.jpg": png, "/photos/4x3/photo.jpg": png, "/logo.png": png,
+		"/fred": png, "/thumb.gif": png,
+	})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	sources := make(map[string]string)
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			t.Errorf("unexpected error: %v", entry.Error)
+		}
+		sources[strings.TrimPrefix(entry.SourceURL, server.URL)] = entry.ElementType
+	}
+	expected := map[string]string{
+		"/photos/1x1/photo.jpg": "JSON-LD", "/photos/4x3/photo.jpg": "JSON-LD",
+		"/logo.png": "JSON-LD", "/fred": "JSON-LD", "/thumb.gif": "JSON-LD",
+	}
+	if diff := cmp.Diff(expected, sources); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+}
+
+func TestIconLinks(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><head>
+		<link rel="icon" href="/favicon" type="image/x-icon">
+		<link rel="shortcut icon" href="/shortcut.ico">
+		<link rel="apple-touch-icon" href="/touch">
+		<link rel="mask-icon" href="/mask" type="image/svg+xml">
+		<link rel="stylesheet" href="/style">
+	</head><body></body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/favicon": png, "/shortcut.ico": png, "/touch": png, "/mask": png, "/style": png,
+	})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	names := downloadedNames(t, collect(feedback))
+	expected := []string{"favicon.ico", "mask.svg", "shortcut.ico", "touch.png"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+}
+
+func TestLazyImages(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body>
+		<img src="placeholder.gif" data-src="real.jpg">
+		<img src="placeholder.gif" data-srcset="small.jpg 1x, large.jpg 2x">
+		<img src="plain.png">
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/placeholder.gif": png, "/real.jpg": png, "/small.jpg": png,
+		"/large.jpg": png, "/plain.png": png,
+	})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	names := downloadedNames(t, collect(feedback))
+	expected := []string{"large.jpg", "plain.png", "real.jpg"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+}
+
+func TestDownloadCancellation(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body>
+			<img src="/fast.png"><img src="/slow1.png">
+			<img src="/slow2.png"><img src="/slow3.png">
+		</body></html>`))
+	})
+	mux.HandleFunc("/fast.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(png)
+	})
+	for _, name := range []string{"/slow1.png", "/slow2.png", "/slow3.png"} {
+		// slow images are served only when the request is aborted
+		mux.HandleFunc(name, func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		})
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesContext(ctx, server.URL, t.TempDir(),
+		downloader.DefaultConfig(), feedback)
+
+	first, ok := <-feedback
+	if !ok || first.Error != nil {
+		t.Fatalf("expected first image to be downloaded, got %+v", first)
+	}
+	cancel()
+
+	if entries := collect(feedback); len(entries) != 0 {
+		t.Errorf("expected no entries after cancellation, got %+v", entries)
+	}
+}
+
+func TestDownloadSummary(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body>
+		<img src="/one.png"><img src="/two.png"><img src="/missing.png">
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/one.png": png, "/two.png": png})
+
+	summary, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		t.TempDir(), downloader.DefaultConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Found != 3 || summary.Downloaded != 2 || summary.Failed != 1 {
+		t.Errorf("unexpected counts in summary: %+v", summary)
+	}
+	if summary.Bytes != int64(2*len(png)) {
+		t.Errorf("summary.Bytes = %d, want %d", summary.Bytes, 2*len(png))
+	}
+	if summary.Elapsed <= 0 {
+		t.Errorf("summary.Elapsed is not set")
+	}
+}
+
+func TestDownloadConcurrency(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	var (
+		mu                sync.Mutex
+		inFlight, maxSeen int
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body>
+			<img src="/1.png"><img src="/2.png"><img src="/3.png"><img src="/4.png">
+		</body></html>`))
+	})
+	for _, name := range []string{"/1.png", "/2.png", "/3.png", "/4.png"} {
+		mux.HandleFunc(name, func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxSeen {
+				maxSeen = inFlight
+			}
+			mu.Unlock()
+
+			time.Sleep(delay)
+
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			w.Header().Set("Content-Type", "image/png")
+		})
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := downloader.DefaultConfig()
+	config.Concurrency = 1
+	start := time.Now()
+	summary, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		t.TempDir(), config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Downloaded != 4 {
+		t.Fatalf("expected 4 downloads, got %+v", summary)
+	}
+	if maxSeen != 1 {
+		t.Errorf("expected serial downloads, got %d simultaneous", maxSeen)
+	}
+	if elapsed := time.Since(start); elapsed < 4*delay {
+		t.Errorf("downloads finished in %v, expected at least %v", elapsed, 4*delay)
+	}
+}
+
+func TestMaxPerHost(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	var (
+		mu              sync.Mutex
+		inFlight        = make(map[string]int)
+		maxSeen         = make(map[string]int)
+		total, maxTotal int
+	)
+	imageServer := func(host string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			inFlight[host]++
+			if inFlight[host] > maxSeen[host] {
+				maxSeen[host] = inFlight[host]
+			}
+			total++
+			if total > maxTotal {
+				maxTotal = total
+			}
+			mu.Unlock()
+
+			time.Sleep(delay)
+
+			mu.Lock()
+			inFlight[host]--
+			total--
+			mu.Unlock()
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+		}))
+	}
+	first, second := imageServer("first"), imageServer("second")
+	defer first.Close()
+	defer second.Close()
+
+	server := newPageServer(t, fmt.Sprintf(`<html><body>
+		<img src="%[1]s/1.png"><img src="%[2]s/1.png">
+		<img src="%[1]s/2.png"><img src="%[2]s/2.png">
+	</body></html>`, first.URL, second.URL), nil)
+
+	config := downloader.DefaultConfig()
+	config.Concurrency = 4
+	config.MaxPerHost = 1
+	summary, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		t.TempDir(), config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Downloaded != 4 {
+		t.Fatalf("expected 4 downloads, got %+v", summary)
+	}
+	for _, host := range []string{"first", "second"} {
+		if maxSeen[host] != 1 {
+			t.Errorf("expected serial downloads from %s host, got %d simultaneous",
+				host, maxSeen[host])
+		}
+	}
+	if maxTotal != 2 {
+		t.Errorf("expected downloads from both hosts to interleave, got %d simultaneous",
+			maxTotal)
+	}
+}
+
+func TestOrderedFeedback(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	// later images finish first
+	delays := map[string]time.Duration{
+		"/1.png": 80 * time.Millisecond, "/2.png": 40 * time.Millisecond,
+		"/3.png": 0, "/4.png": 20 * time.Millisecond,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body>
+			<img src="/1.png"><img src="/2.png"><img src="/3.png"><img src="/4.png">
+		</body></html>`))
+	})
+	for name, delay := range delays {
+		delay := delay
+		mux.HandleFunc(name, func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			w.Write(png)
+		})
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := downloader.DefaultConfig()
+	config.Concurrency = 4
+	config.OrderedFeedback = true
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+	var urls []string
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			t.Errorf("unexpected error: %v", entry.Error)
+		}
+		urls = append(urls, strings.TrimPrefix(entry.SourceURL, server.URL))
+	}
+	expected := []string{"/1.png", "/2.png", "/3.png", "/4.png"}
+	if diff := cmp.Diff(expected, urls); diff != "" {
+		t.Errorf("unexpected order (-want +got):\n%s", diff)
+	}
+}
+
+func TestSkipExisting(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	var requests int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><img src="/pixel.png"></body></html>`))
+	})
+	mux.HandleFunc("/pixel.png", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write(png)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := downloader.DefaultConfig()
+	config.SkipExisting = true
+	run := func(dir string) downloader.DownloadEntry {
+		feedback := make(chan downloader.DownloadEntry)
+		go downloader.DownloadImagesWithConfig(server.URL, dir, config, feedback)
+		entries := collect(feedback)
+		if len(entries) != 1 || entries[0].Error != nil {
+			t.Fatalf("expected single successful entry, got %+v", entries)
+		}
+		return entries[0]
+	}
+
+	t.Run("absent", func(t *testing.T) {
+		entry := run(t.TempDir())
+		if entry.Skipped {
+			t.Errorf("absent file reported as skipped")
+		}
+		if atomic.LoadInt32(&requests) != 1 {
+			t.Errorf("image was not requested")
+		}
+	})
+
+	t.Run("present", func(t *testing.T) {
+		dir := t.TempDir()
+		existing := filepath.Join(dir, "pixel.png")
+		if err := os.WriteFile(existing, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		before := atomic.LoadInt32(&requests)
+		entry := run(dir)
+		if !entry.Skipped || entry.Filename != existing {
+			t.Errorf("expected %s to be skipped, got %+v", existing, entry)
+		}
+		if atomic.LoadInt32(&requests) != before {
+			t.Errorf("existing image was requested again")
+		}
+		if data, _ := os.ReadFile(existing); string(data) != "old" {
+			t.Errorf("existing file was overwritten")
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		dir := t.TempDir()
+		existing := filepath.Join(dir, "pixel.png")
+		if err := os.WriteFile(existing, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		before := atomic.LoadInt32(&requests)
+		entry := run(dir)
+		if entry.Skipped || entry.Filename != existing {
+			t.Errorf("expected %s to be downloaded again, got %+v", existing, entry)
+		}
+		if atomic.LoadInt32(&requests) != before+1 {
+			t.Errorf("image was not requested")
+		}
+		if data, _ := os.ReadFile(existing); !bytes.Equal(data, png) {
+			t.Errorf("empty file was not replaced")
+		}
+	})
+}
+
+func TestSkipExistingFinalName(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	// the URL has no extension, the file is named after the response type
+	server := newPageServer(t, `<html><body><img src="/photo"></body></html>`,
+		map[string][]byte{"/photo": png})
+	dir := t.TempDir()
+
+	config := downloader.DefaultConfig()
+	config.SkipExisting = true
+	for run, skipped := range []bool{false, true} {
+		feedback := make(chan downloader.DownloadEntry)
+		go downloader.DownloadImagesWithConfig(server.URL, dir, config, feedback)
+		entries := collect(feedback)
+		if len(entries) != 1 || entries[0].Error != nil || entries[0].Skipped != skipped ||
+			entries[0].Filename != filepath.Join(dir, "photo.png") {
+			t.Errorf("run %d: unexpected entries %+v", run+1, entries)
+		}
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected single file, got %d", len(files))
+	}
+}
+
+func TestOnExisting(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	server := newPageServer(t, `<html><body><img src="/pixel.png"></body></html>`,
+		map[string][]byte{"/pixel.png": png})
+	inlineServer := newPageServer(t,
+		`<html><body><img src="data:image/png;base64,`+pixelPNG+`"></body></html>`, nil)
+
+	sources := []struct {
+		name     string
+		url      string
+		template string
+		base     string
+	}{
+		{"remote", server.URL, "", "pixel"},
+		{"inline", inlineServer.URL, "inline-{index}.{ext}", "inline-1"},
+	}
+	tests := []struct {
+		name    string
+		policy  downloader.ExistingPolicy
+		suffix  string
+		content string
+		skipped bool
+		exists  bool
+	}{
+		{"rename", downloader.ExistingRename, " (1)", "old", false, false},
+		{"overwrite", downloader.ExistingOverwrite, "", string(png), false, false},
+		{"skip", downloader.ExistingSkip, "", "old", true, false},
+		{"error", downloader.ExistingError, "", "old", false, true},
+	}
+	for _, source := range sources {
+		for _, test := range tests {
+			source, test := source, test
+			t.Run(source.name+"/"+test.name, func(t *testing.T) {
+				dir := t.TempDir()
+				existing := filepath.Join(dir, source.base+".png")
+				if err := os.WriteFile(existing, []byte("old"), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				config := downloader.DefaultConfig()
+				config.OnExisting = test.policy
+				config.InlineFilenameTemplate = source.template
+				feedback := make(chan downloader.DownloadEntry)
+				go downloader.DownloadImagesWithConfig(source.url, dir, config, feedback)
+
+				entries := collect(feedback)
+				if len(entries) != 1 {
+					t.Fatalf("expected single entry, got %+v", entries)
+				}
+				entry := entries[0]
+				expected := filepath.Join(dir, source.base+test.suffix+".png")
+				if test.exists {
+					if !errors.Is(entry.Error, os.ErrExist) ||
+						!strings.Contains(entry.Error.Error(), existing) {
+						t.Errorf("expected error naming the existing file, got %v", entry.Error)
+					}
+					expected = ""
+				} else if entry.Error != nil {
+					t.Errorf("unexpected error: %v", entry.Error)
+				}
+				if entry.Filename != expected || entry.Skipped != test.skipped {
+					t.Errorf("unexpected entry %+v", entry)
+				}
+				if data, _ := os.ReadFile(existing); string(data) != test.content {
+					t.Errorf("existing file contains %q, want %q", data, test.content)
+				}
+			})
+		}
+	}
+}
+
+func TestDuplicateImages(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	svg := `<svg width="1" height="1"><rect width="1" height="1"></rect></svg>`
+	page := `<html><head><link rel="icon" href="/logo.png"></head><body>
+		<a href="logo.png"><img src="/logo.png"></a>
+		` + svg + svg + `
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/logo.png": png})
+
+	summary, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		t.TempDir(), downloader.DefaultConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Found != 2 || summary.Downloaded != 2 {
+		t.Errorf("expected one remote and one inline image, got %+v", summary)
+	}
+}
+
+func TestFilenameCollisions(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body>
+		<img src="/images/a/logo.png"><img src="/images/b/logo.png"><img src="/images/c/logo.png">
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/images/a/logo.png": png, "/images/b/logo.png": png, "/images/c/logo.png": png,
+	})
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "logo.png"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, dir, feedback)
+
+	names := downloadedNames(t, collect(feedback))
+	expected := []string{"logo (1).png", "logo (2).png", "logo (3).png"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "logo.png")); string(data) != "old" {
+		t.Errorf("existing file was overwritten")
+	}
+}
+
+func TestFilenameTemplate(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body><img src="/img/logo.png"><img src="/img/photo"></body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/img/logo.png": png, "/img/photo": png})
+
+	type testCase struct {
+		template string
+		files    []string
+	}
+
+	var testCases = []testCase{
+		{"", []string{"logo.png", "photo.png"}},
+		{"{host}_{basename}.{ext}", []string{"127.0.0.1_logo.png", "127.0.0.1_photo.png"}},
+		{"{index}-{basename}", []string{"1-logo", "2-photo"}},
+		{`img<{index}>|{basename}:{ext}`, []string{"img_1__logo_png", "img_2__photo_png"}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.template, func(t *testing.T) {
+			config := downloader.DefaultConfig()
+			config.FilenameTemplate = test.template
+			feedback := make(chan downloader.DownloadEntry)
+			go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+			names := downloadedNames(t, collect(feedback))
+			if !cmp.Equal(names, test.files) {
+				t.Errorf("downloaded %v, want %v", names, test.files)
+			}
+		})
+	}
+}
+
+func TestInlineFilenameTemplate(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	const svg = `<svg width="1" height="1"><rect width="1" height="1"></rect></svg>`
+	pngHash, svgHash := sha256.Sum256(png), sha256.Sum256([]byte(svg))
+	page := `<html><body>
+		<img src="/photo.png">
+		<img src="data:image/png;base64,` + pixelPNG + `">
+		` + svg + `
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/photo.png": png})
+
+	type testCase struct {
+		template string
+		files    []string
+	}
+
+	var testCases = []testCase{
+		{"inline-{index}.{ext}", []string{"inline-2.png", "inline-3.svg", "photo.png"}},
+		{"{hash}.{ext}", []string{hex.EncodeToString(pngHash[:]) + ".png",
+			hex.EncodeToString(svgHash[:]) + ".svg", "photo.png"}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.template, func(t *testing.T) {
+			config := downloader.DefaultConfig()
+			config.InlineFilenameTemplate = test.template
+			feedback := make(chan downloader.DownloadEntry)
+			go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+			names := downloadedNames(t, collect(feedback))
+			sort.Strings(test.files)
+			if !cmp.Equal(names, test.files) {
+				t.Errorf("downloaded %v, want %v", names, test.files)
+			}
+		})
+	}
+}
+
+func TestExtensionFromResponse(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><img src="/header"><img src="/sniffed"></body></html>`))
+	})
+	mux.HandleFunc("/header", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/gif")
+		w.Write(png)
+	})
+	mux.HandleFunc("/sniffed", func(w http.ResponseWriter, r *http.Request) {
+		// prevent server from setting Content-Type
+		w.Header()["Content-Type"] = nil
+		w.Write(png)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	names := downloadedNames(t, collect(feedback))
+	expected := []string{"header.gif", "sniffed.png"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	var flakyRequests, missingRequests int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><img src="/flaky.png"><img src="/missing.png"></body></html>`))
+	})
+	mux.HandleFunc("/flaky.png", func(w http.ResponseWriter, r *http.Request) {
+		// fail twice, then succeed
+		if atomic.AddInt32(&flakyRequests, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(png)
+	})
+	mux.HandleFunc("/missing.png", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&missingRequests, 1)
+		http.NotFound(w, r)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := downloader.DefaultConfig()
+	config.MaxAttempts = 3
+	config.RetryDelay = time.Millisecond
+	summary, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		t.TempDir(), config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Downloaded != 1 || summary.Failed != 1 {
+		t.Errorf("expected flaky image to be downloaded, got %+v", summary)
+	}
+	if flakyRequests != 3 {
+		t.Errorf("flaky image requested %d times, want 3", flakyRequests)
+	}
+	if missingRequests != 1 {
+		t.Errorf("missing image requested %d times, want 1", missingRequests)
+	}
+}
+
+func TestRetryPermanentError(t *testing.T) {
+	server := newPageServer(t, `<html><body><img src="ftp://example.com/photo.png"></body></html>`, nil)
+
+	logger := &recordingLogger{}
+	config := downloader.DefaultConfig()
+	config.Logger = logger
+	config.IgnoreRobots = true
+	config.MaxAttempts = 3
+	config.RetryDelay = time.Millisecond
+	summary, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		t.TempDir(), config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Failed != 1 {
+		t.Errorf("expected image to fail, got %+v", summary)
+	}
+	for _, message := range logger.messages {
+		if strings.HasPrefix(message, "Retrying") {
+			t.Errorf("unsupported scheme is retried: %s", message)
+		}
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	var (
+		mu       sync.Mutex
+		received []http.Header
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = append(received, r.Header.Clone())
+		mu.Unlock()
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body><img src="/pixel.png"></body></html>`))
+			return
+		}
+		w.Write(png)
+	}))
+	defer server.Close()
+
+	config := downloader.DefaultConfig()
+	config.UserAgent = "imagedown-test/1.0"
+	config.Headers = map[string]string{"Referer": "https://example.com/"}
+	summary, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		t.TempDir(), config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Downloaded != 1 {
+		t.Fatalf("expected image to be downloaded, got %+v", summary)
+	}
+	if len(received) != 3 {
+		t.Fatalf("expected robots.txt, page and image requests, got %d", len(received))
+	}
+	for _, header := range received {
+		if ua := header.Get("User-Agent"); ua != config.UserAgent {
+			t.Errorf("User-Agent = %q, want %q", ua, config.UserAgent)
+		}
+		if referer := header.Get("Referer"); referer != "https://example.com/" {
+			t.Errorf("Referer = %q, want %q", referer, "https://example.com/")
+		}
+	}
+}
+
+func TestMinBytes(t *testing.T) {
+	const minBytes = 100
+	serve := func(size int, chunked bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			if chunked {
+				// flushing before writing body omits Content-Length
+				w.(http.Flusher).Flush()
+			}
+			w.Write(bytes.Repeat([]byte{0}, size))
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body>
+			<img src="/under.png"><img src="/over.png">
+			<img src="/chunked-under.png"><img src="/chunked-over.png">
+		</body></html>`))
+	})
+	mux.HandleFunc("/under.png", serve(minBytes-1, false))
+	mux.HandleFunc("/over.png", serve(minBytes+1, false))
+	mux.HandleFunc("/chunked-under.png", serve(minBytes-1, true))
+	mux.HandleFunc("/chunked-over.png", serve(minBytes+1, true))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := downloader.DefaultConfig()
+	config.MinBytes = minBytes
+	dir := t.TempDir()
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, dir, config, feedback)
+
+	var filtered int
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			t.Errorf("unexpected error: %v", entry.Error)
+		} else if entry.Filtered {
+			filtered++
+		} else if info, err := os.Stat(entry.Filename); err != nil || info.Size() != minBytes+1 {
+			t.Errorf("unexpected file %s: %v", entry.Filename, err)
+		}
+	}
+	if filtered != 2 {
+		t.Errorf("expected 2 filtered images, got %d", filtered)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 2 {
+		t.Errorf("expected 2 files on disk, got %v", files)
+	}
+}
+
+func TestEntrySource(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body>
+		<img src="/found.png"><a href="/missing.jpg">link</a>
+		<svg width="1" height="1"><rect width="1" height="1"></rect></svg>
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/found.png": png})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	entries := make(map[string]downloader.DownloadEntry)
+	for _, entry := range collect(feedback) {
+		entries[entry.ElementType] = entry
+	}
+
+	if found := entries["<img>"]; found.SourceURL != server.URL+"/found.png" || found.Error != nil {
+		t.Errorf("unexpected <img> entry: %+v", found)
+	}
+	if missing := entries["<a>"]; missing.SourceURL != server.URL+"/missing.jpg" ||
+		missing.Error == nil {
+		t.Errorf("unexpected <a> entry: %+v", missing)
+	}
+	if inline := entries["<svg>"]; len(inline.SourceURL) > 0 || inline.Error != nil {
+		t.Errorf("unexpected <svg> entry: %+v", inline)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body><img src="/1.png"><img src="/2.png"><img src="/3.png"></body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/1.png": png, "/2.png": png, "/3.png": png})
+
+	config := downloader.DefaultConfig()
+	config.IgnoreRobots = true
+	config.Concurrency = 3
+	config.RateLimit = 10
+	start := time.Now()
+	summary, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		t.TempDir(), config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Downloaded != 3 {
+		t.Fatalf("expected 3 downloads, got %+v", summary)
+	}
+	// the page and 3 images, the first request is not delayed
+	if elapsed, expected := time.Since(start), 300*time.Millisecond; elapsed < expected {
+		t.Errorf("4 requests took %v, expected at least %v", elapsed, expected)
+	}
+}
+
+func TestUnreachablePage(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	pageURL := server.URL
+	server.Close()
+
+	config := downloader.DefaultConfig()
+	config.MaxAttempts = 1
+	feedback := make(chan downloader.DownloadEntry)
+	done := make(chan []downloader.DownloadEntry)
+	go func() { done <- collect(feedback) }()
+
+	summary, err := downloader.DownloadImagesWithSummary(context.Background(), pageURL,
+		t.TempDir(), config, feedback)
+	if err == nil {
+		t.Errorf("expected error for unreachable page, got %+v", summary)
+	}
+	if entries := <-done; len(entries) != 0 {
+		t.Errorf("fatal error should not be sent to feedback, got %+v", entries)
+	}
+}
+
+func TestPageMediaTypes(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	tests := []struct {
+		name        string
+		contentType []string
+		page        string
+	}{
+		{"xhtml", []string{"application/xhtml+xml; charset=utf-8"},
+			`<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><body><img src="/pixel.png"/></body></html>`},
+		{"missing", nil, `<html><body><img src="/pixel.png"></body></html>`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/pixel.png" {
+					w.Write(png)
+					return
+				}
+				// nil value stops the server from sniffing the type
+				w.Header()["Content-Type"] = test.contentType
+				w.Write([]byte(test.page))
+			}))
+			defer server.Close()
+
+			feedback := make(chan downloader.DownloadEntry)
+			go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+			names := downloadedNames(t, collect(feedback))
+			if expected := []string{"pixel.png"}; !cmp.Equal(names, expected) {
+				t.Errorf("downloaded %v, want %v", names, expected)
+			}
+		})
+	}
+}
+
+func TestMaxPageBytes(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body><img src="/pixel.png">` + strings.Repeat("<p>filler</p>", 1000) +
+		`</body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/pixel.png": png})
+
+	config := downloader.DefaultConfig()
+	config.MaxPageBytes = int64(len(page)) - 1
+	_, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		t.TempDir(), config, nil)
+	if !errors.Is(err, downloader.ErrPageTooLarge) {
+		t.Fatalf("expected ErrPageTooLarge, got %v", err)
+	}
+
+	config.MaxPageBytes = int64(len(page))
+	summary, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		t.TempDir(), config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Downloaded != 1 {
+		t.Errorf("expected 1 download, got %+v", summary)
+	}
+}
+
+func TestPageFetchCancelled(t *testing.T) {
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><img src="/pixel.png">`))
+		w.(http.Flusher).Flush()
+		close(started)
+		// the rest of the page never arrives
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	config := downloader.DefaultConfig()
+	config.IgnoreRobots = true
+	_, err := downloader.DownloadImagesWithSummary(ctx, server.URL, t.TempDir(), config, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestEncodedResponses(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	var gzipped, deflated bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(`<html><body><img src="/pixel.png"></body></html>`))
+	gz.Close()
+	zw := zlib.NewWriter(&deflated)
+	zw.Write(png)
+	zw.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gzipped.Bytes())
+	})
+	mux.HandleFunc("/pixel.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Encoding", "deflate")
+		w.Write(deflated.Bytes())
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := downloader.DefaultConfig()
+	// explicit Accept-Encoding disables transparent decompression
+	config.Headers = map[string]string{"Accept-Encoding": "gzip, deflate"}
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+	entries := collect(feedback)
+	if len(entries) != 1 || entries[0].Error != nil {
+		t.Fatalf("expected single downloaded image, got %+v", entries)
+	}
+	if data, _ := os.ReadFile(entries[0].Filename); !bytes.Equal(data, png) {
+		t.Errorf("downloaded image is not decompressed")
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><img src="/hop/3/a.png"><img src="/hop/6/b.png"></body></html>`))
+	})
+	// "/hop/N/name" redirects N more times before reaching "/final-name"
+	mux.HandleFunc("/hop/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/")
+		hops, _ := strconv.Atoi(parts[2])
+		if hops == 0 {
+			http.Redirect(w, r, "/final-"+parts[3], http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/hop/"+strconv.Itoa(hops-1)+"/"+parts[3], http.StatusFound)
+	})
+	mux.HandleFunc("/final-a.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := downloader.DefaultConfig()
+	config.MaxRedirects = 5
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+	var failed []downloader.DownloadEntry
+	var downloaded []downloader.DownloadEntry
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			failed = append(failed, entry)
+		} else {
+			downloaded = append(downloaded, entry)
+		}
+	}
+	if diff := cmp.Diff([]string{"final-a.png"}, downloadedNames(t, downloaded)); diff != "" {
+		t.Errorf("unexpected downloaded images (-want +got):\n%s", diff)
+	}
+	if len(failed) != 1 || !errors.Is(failed[0].Error, downloader.ErrTooManyRedirects) {
+		t.Errorf("expected single too many redirects error, got %+v", failed)
+	}
+}
+
+func TestDownloadFromReader(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	server := newPageServer(t, "", map[string][]byte{"/images/pixel.png": png})
+	page := `<html><body><img src="images/pixel.png"></body></html>`
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesFromReader(strings.NewReader(page), server.URL+"/",
+		t.TempDir(), feedback)
+
+	names := downloadedNames(t, collect(feedback))
+	expected := []string{"pixel.png"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+}
+
+func TestCustomImageExtension(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body><a href="/photo.foo">photo</a></body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/photo.foo": png})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+	if entries := collect(feedback); len(entries) != 0 {
+		t.Fatalf("unexpected entries for unknown extension: %+v", entries)
+	}
+
+	downloader.RegisterImageExtensions("foo")
+	defer downloader.UnregisterImageExtensions("foo")
+
+	feedback = make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	names := downloadedNames(t, collect(feedback))
+	expected := []string{"photo.foo"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+}
+
+func TestModernImageObjects(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body>
+		<object data="/photo" type="image/avif"></object>
+		<embed src="/still" type="image/heic">
+		<img src="data:image/jxl;base64,` + pixelPNG + `">
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/photo": png, "/still": png})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	var exts []string
+	for _, name := range downloadedNames(t, collect(feedback)) {
+		exts = append(exts, filepath.Ext(name))
+	}
+	sort.Strings(exts)
+	expected := []string{".avif", ".heic", ".jxl"}
+	if !cmp.Equal(exts, expected) {
+		t.Errorf("downloaded extensions %v, want %v", exts, expected)
+	}
+}
+
+func TestListOnly(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body>
+		<img src="/a.png"><a href="/b.jpg">b</a>
+		<svg width="1" height="1"><rect width="1" height="1"/></svg>
+	</body></html>`
+	var requests int32
+	server := newPageServer(t, page, map[string][]byte{"/a.png": png, "/b.jpg": png})
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		handler.ServeHTTP(w, r)
+	})
+	dir := t.TempDir()
+
+	config := downloader.DefaultConfig()
+	config.ListOnly = true
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, dir, config, feedback)
+
+	found := make(map[string]string)
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil || len(entry.Filename) > 0 {
+			t.Errorf("unexpected entry: %+v", entry)
+		}
+		found[entry.SourceURL] = entry.ElementType
+	}
+	expected := map[string]string{
+		server.URL + "/a.png": "<img>", server.URL + "/b.jpg": "<a>", "": "<svg>",
+	}
+	if diff := cmp.Diff(expected, found); diff != "" {
+		t.Errorf("unexpected listed images (-want +got):\n%s", diff)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected no files, got %d", len(files))
+	}
+	// the page and robots.txt only
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+}
+
+func TestEntryBytes(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	large := bytes.Repeat([]byte{0xff}, 10000)
+	page := `<html><body>
+		<img src="/pixel.png"><img src="/large.jpg">
+		<img src="data:image/png;base64,` + pixelPNG + `">
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/pixel.png": png, "/large.jpg": large})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	entries := collect(feedback)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %+v", entries)
+	}
+	for _, entry := range entries {
+		if entry.Error != nil {
+			t.Errorf("unexpected error: %v", entry.Error)
+			continue
+		}
+		info, err := os.Stat(entry.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Bytes != info.Size() {
+			t.Errorf("%s: reported %d bytes, file has %d", entry.Filename, entry.Bytes,
+				info.Size())
+		}
+	}
+}
+
+func TestMaxTotalBytes(t *testing.T) {
+	image := bytes.Repeat([]byte{0xff}, 10000)
+	page := `<html><body>
+		<img src="/1.jpg"><img src="/2.jpg"><img src="/3.jpg"><img src="/4.jpg"><img src="/5.jpg">
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/1.jpg": image, "/2.jpg": image, "/3.jpg": image, "/4.jpg": image, "/5.jpg": image,
+	})
+
+	config := downloader.DefaultConfig()
+	config.Concurrency = 1
+	config.MaxTotalBytes = 25000
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+	entries := collect(feedback)
+	if len(entries) == 0 {
+		t.Fatalf("no entries received")
+	}
+	last := entries[len(entries)-1]
+	if !errors.Is(last.Error, downloader.ErrTotalBytesExceeded) {
+		t.Errorf("expected the last entry to report exceeded limit, got %+v", last)
+	}
+	var downloaded []downloader.DownloadEntry
+	for _, entry := range entries[:len(entries)-1] {
+		if entry.Error != nil {
+			// the image which exceeded the limit
+			if !errors.Is(entry.Error, downloader.ErrTotalBytesExceeded) ||
+				entry.SourceURL != server.URL+"/3.jpg" {
+				t.Errorf("unexpected error entry %+v", entry)
+			}
+			continue
+		}
+		downloaded = append(downloaded, entry)
+	}
+	names := downloadedNames(t, downloaded)
+	expected := []string{"1.jpg", "2.jpg"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+}
+
+func TestMaxTotalBytesStreaming(t *testing.T) {
+	chunk := bytes.Repeat([]byte{0xff}, 4096)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body><img src="/endless.jpg"></body></html>`))
+			return
+		}
+		// no Content-Length, the body never ends unless the client stops
+		w.Header().Set("Content-Type", "image/jpeg")
+		for r.Context().Err() == nil {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	config := downloader.DefaultConfig()
+	config.MaxTotalBytes = 100000
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, dir, config, feedback)
+
+	entries := collect(feedback)
+	if len(entries) != 2 {
+		t.Fatalf("expected truncated image and limit entries, got %+v", entries)
+	}
+	for _, entry := range entries {
+		if !errors.Is(entry.Error, downloader.ErrTotalBytesExceeded) {
+			t.Errorf("expected exceeded limit, got %+v", entry)
+		}
+	}
+	if entries[0].Filename != filepath.Join(dir, "endless.jpg") ||
+		entries[0].Bytes != config.MaxTotalBytes {
+		t.Errorf("expected truncated file to be reported, got %+v", entries[0])
+	}
+	info, err := os.Stat(filepath.Join(dir, "endless.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != config.MaxTotalBytes {
+		t.Errorf("stored %d bytes, want %d", info.Size(), config.MaxTotalBytes)
+	}
+}
+
+func TestMaxTotalBytesSkipAnimated(t *testing.T) {
+	chunk := bytes.Repeat([]byte{0xff}, 4096)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body><img src="/endless.gif"></body></html>`))
+			return
+		}
+		// GIF is buffered to count its frames, the body never ends
+		w.Write([]byte("GIF89a"))
+		for r.Context().Err() == nil {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	config := downloader.DefaultConfig()
+	config.SkipAnimated = true
+	config.MaxTotalBytes = 100000
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, dir, config, feedback)
+
+	entries := collect(feedback)
+	if len(entries) != 2 || !errors.Is(entries[0].Error, downloader.ErrTotalBytesExceeded) {
+		t.Fatalf("expected truncated image and limit entries, got %+v", entries)
+	}
+	info, err := os.Stat(filepath.Join(dir, "endless.gif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != config.MaxTotalBytes {
+		t.Errorf("stored %d bytes, want %d", info.Size(), config.MaxTotalBytes)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := "<html><body>"
+	files := make(map[string][]byte)
+	for i := 1; i <= 10; i++ {
+		name := "/" + strconv.Itoa(i) + ".png"
+		page += `<img src="` + name + `">`
+		files[name] = png
+	}
+	server := newPageServer(t, page+"</body></html>", files)
+
+	config := downloader.DefaultConfig()
+	config.Limit = 3
+	summary, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		t.TempDir(), config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Found != 10 || summary.Downloaded != 3 {
+		t.Errorf("expected 10 found and 3 downloaded, got %+v", summary)
+	}
+}
+
+func TestProtocolRelativeURL(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/pixel.png" {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><img src="//` + r.Host + `/pixel.png"></body></html>`))
+	}
+
+	for name, server := range map[string]*httptest.Server{
+		"http":  httptest.NewServer(http.HandlerFunc(handler)),
+		"https": httptest.NewTLSServer(http.HandlerFunc(handler)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer server.Close()
+
+			config := downloader.DefaultConfig()
+			config.InsecureSkipVerify = true
+			config.MaxAttempts = 1
+			feedback := make(chan downloader.DownloadEntry)
+			go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+			entries := collect(feedback)
+			if len(entries) != 1 || entries[0].Error != nil {
+				t.Fatalf("expected single downloaded image, got %+v", entries)
+			}
+			if expected := server.URL + "/pixel.png"; entries[0].SourceURL != expected {
+				t.Errorf("resolved %s, want %s", entries[0].SourceURL, expected)
+			}
+		})
+	}
+}
+
+func TestBaseHref(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	cdn := newPageServer(t, "", map[string][]byte{
+		"/assets/logo.png": png, "/photo.jpg": png,
+	})
+	page := `<html><head><base href="` + cdn.URL + `/assets/"></head><body>
+		<img src="logo.png"><img src="/photo.jpg">
+	</body></html>`
+	server := newPageServer(t, page, nil)
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	var urls []string
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			t.Errorf("unexpected error: %v", entry.Error)
+		}
+		urls = append(urls, entry.SourceURL)
+	}
+	sort.Strings(urls)
+	expected := []string{cdn.URL + "/assets/logo.png", cdn.URL + "/photo.jpg"}
+	if diff := cmp.Diff(expected, urls); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+}
+
+func TestURLQueryAndFragment(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body>
+		<img src="/photo.jpg?v=123"><a href="/logo.gif#top">logo</a>
+		<img src="/image.php?type=png">
+		<div style="background: url('/bg.webp?size=large#x')"></div>
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/photo.jpg": png, "/logo.gif": png, "/image.php": png, "/bg.webp": png,
+	})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	names := downloadedNames(t, collect(feedback))
+	expected := []string{"bg.webp", "image.png", "logo.gif", "photo.jpg"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+}
+
+func TestSanitizedFilenames(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body>
+		<img src="/my%20photo.png"><img src="/img/..%5Csecret.png"><img src="/a:b.png">
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/my photo.png": png, "/img/..\\secret.png": png, "/a:b.png": png,
+	})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	names := downloadedNames(t, collect(feedback))
+	expected := []string{"_secret.png", "a_b.png", "my photo.png"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+}
+
+func TestLongFilenames(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	long := strings.Repeat("a", 300)
+	accented := strings.Repeat("é", 200)
+	page := `<html><body>
+		<img src="/` + long + `1.png"><img src="/` + long + `2.png">
+		<img src="/` + url.PathEscape(accented) + `.png">
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/" + long + "1.png": png, "/" + long + "2.png": png, "/" + accented + ".png": png,
+	})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	names := downloadedNames(t, collect(feedback))
+	if len(names) != 3 || names[0] == names[1] {
+		t.Fatalf("expected 3 distinct files, got %v", names)
+	}
+	for _, name := range names {
+		if len(name) > 255 || !strings.HasSuffix(name, ".png") || !utf8.ValidString(name) {
+			t.Errorf("invalid file name %q of %d bytes", name, len(name))
+		}
+	}
+}
+
+func TestInvalidImageURL(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body><img src="/img%zz.png"><img src="/pixel.png"></body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/pixel.png": png})
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	var failed, downloaded []downloader.DownloadEntry
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			failed = append(failed, entry)
+		} else {
+			downloaded = append(downloaded, entry)
+		}
+	}
+	if len(failed) != 1 || !errors.Is(failed[0].Error, downloader.ErrInvalidURL) ||
+		failed[0].SourceURL != "/img%zz.png" || failed[0].ElementType != "<img>" {
+		t.Errorf("expected invalid URL error, got %+v", failed)
+	}
+	if len(failed) == 1 && !strings.Contains(failed[0].Error.Error(), "/img%zz.png") {
+		t.Errorf("error %q does not mention the URL", failed[0].Error)
+	}
+	if len(downloaded) != 1 || filepath.Base(downloaded[0].Filename) != "pixel.png" {
+		t.Errorf("unexpected downloads: %+v", downloaded)
+	}
+}
+
+func TestInvalidDataURLs(t *testing.T) {
+	page := `<html><body>
+		<img src="data:image/x-foo;base64,AAAA">
+		<img src="data:image/png,not-base64">
+		<img src="data:image/png;base64,` + pixelPNG + `">
+		<a href="data:text/plain,hello">note</a>
+		<picture><source srcset="data:image/png,not-base64"></picture>
+	</body></html>`
+	server := newPageServer(t, page, nil)
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	var errs []error
+	var elements []string
+	downloaded := 0
+	for _, entry := range collect(feedback) {
+		if entry.Error == nil {
+			downloaded++
+			continue
+		}
+		var dataErr *downloader.DataURLError
+		if !errors.As(entry.Error, &dataErr) {
+			t.Errorf("unexpected entry: %+v", entry)
+		}
+		errs = append(errs, entry.Error)
+		elements = append(elements, entry.ElementType)
+	}
+	if len(errs) != 3 || !errors.Is(errs[0], downloader.ErrUnsupportedImageType) ||
+		!errors.Is(errs[1], downloader.ErrNotBase64) || !errors.Is(errs[2], downloader.ErrNotBase64) {
+		t.Errorf("expected unsupported type and not base64 errors, got %v", errs)
+	}
+	// reported as images of the same elements are
+	if expected := []string{"<img>", "<img>", "<picture>"}; !cmp.Equal(elements, expected) {
+		t.Errorf("errors of %v, want %v", elements, expected)
+	}
+	if downloaded != 1 {
+		t.Errorf("expected 1 download, got %d", downloaded)
+	}
+}
+
+func TestInvalidCSSDataURLs(t *testing.T) {
+	page := `<html><head><link rel="stylesheet" href="/site.css"></head><body>
+		<div style="background: url(data:image/x-foo;base64,AAAA)"></div>
+		<style>
+			.a { background: url('data:image/png,not-base64') }
+			.b { background: url(data:image/png;base64,` + pixelPNG + `) }
+		</style>
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/site.css": []byte(`.c { background: url("data:image/png,not-base64") }`),
+	})
+
+	config := downloader.DefaultConfig()
+	config.FollowStylesheets = true
+	// errors are reported in the page order
+	config.Concurrency = 1
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+	var elements []string
+	downloaded := 0
+	for _, entry := range collect(feedback) {
+		if entry.Error == nil {
+			downloaded++
+			continue
+		}
+		var dataErr *downloader.DataURLError
+		if !errors.As(entry.Error, &dataErr) {
+			t.Errorf("unexpected entry: %+v", entry)
+		}
+		elements = append(elements, entry.ElementType)
+	}
+	if expected := []string{"style attribute", "<style>", "stylesheet"}; !cmp.Equal(elements, expected) {
+		t.Errorf("errors of %v, want %v", elements, expected)
+	}
+	if downloaded != 1 {
+		t.Errorf("expected 1 download, got %d", downloaded)
+	}
+}
+
+func TestCreateOutputDirectory(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	server := newPageServer(t, `<html><body><img src="/pixel.png"></body></html>`,
+		map[string][]byte{"/pixel.png": png})
+	dir := filepath.Join(t.TempDir(), "scrape", "out")
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, dir, feedback)
+	if names := downloadedNames(t, collect(feedback)); !cmp.Equal(names, []string{"pixel.png"}) {
+		t.Fatalf("downloaded %v", names)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pixel.png")); err != nil {
+		t.Errorf("image is not saved into created directory: %v", err)
+	}
+
+	// directory can't be created under a file
+	parent := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(parent, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		filepath.Join(parent, "out"), downloader.DefaultConfig(), nil)
+	if err == nil {
+		t.Errorf("expected error creating directory under a file")
+	}
+}
+
+// recordingLogger collects formatted messages.
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func TestLogger(t *testing.T) {
+	page := `<html><body>
+		<img src="/photo.xyz"><object data="/scan" type="image/x-unknown"></object>
+	</body></html>`
+	server := newPageServer(t, page, nil)
+
+	logger := &recordingLogger{}
+	config := downloader.DefaultConfig()
+	config.Logger = logger
+	config.MaxAttempts = 1
+	// failures are logged in the page order
+	config.Concurrency = 1
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+	collect(feedback)
+
+	expected := []string{
+		"extension xyz is not recognized as image extension",
+		"Mime type is image, but can't match extension for image/x-unknown",
+		"Failed to download <img> image: " + server.URL + "/photo.xyz: received response, 404 Not Found",
+		"Failed to download <object> image: " + server.URL + "/scan: received response, 404 Not Found",
+	}
+	if diff := cmp.Diff(expected, logger.messages); diff != "" {
+		t.Errorf("unexpected log messages (-want +got):\n%s", diff)
+	}
+}
+
+func TestHTTPStatusError(t *testing.T) {
+	server := newPageServer(t, `<html><body><img src="/missing.png"></body></html>`, nil)
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+	entries := collect(feedback)
+	if len(entries) != 1 {
+		t.Fatalf("expected single entry, got %+v", entries)
+	}
+	var statusErr *downloader.HTTPStatusError
+	if !errors.As(entries[0].Error, &statusErr) {
+		t.Fatalf("expected HTTPStatusError, got %v", entries[0].Error)
+	}
+	expected := downloader.HTTPStatusError{URL: server.URL + "/missing.png", Code: http.StatusNotFound,
+		Status: "404 Not Found",
+		Header: http.Header{"Content-Type": {"text/plain; charset=utf-8"}}}
+	if diff := cmp.Diff(expected, *statusErr); diff != "" {
+		t.Errorf("unexpected error (-want +got):\n%s", diff)
+	}
+
+	_, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL+"/missing.html",
+		t.TempDir(), downloader.DefaultConfig(), nil)
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound {
+		t.Errorf("expected HTTPStatusError for the page, got %v", err)
+	}
+}
+
+func TestHTMLErrorPage(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body><img src="/missing.png"><img src="/pixel.png"></body></html>`))
+		case "/pixel.png":
+			w.Write(png)
+		default:
+			// "not found" page with success status
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<html><body>Page not found</body></html>`))
+		}
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImages(server.URL, dir, feedback)
+
+	for _, entry := range collect(feedback) {
+		switch entry.SourceURL {
+		case server.URL + "/missing.png":
+			if !errors.Is(entry.Error, downloader.ErrNotImage) {
+				t.Errorf("expected ErrNotImage, got %+v", entry)
+			}
+		case server.URL + "/pixel.png":
+			if entry.Error != nil {
+				t.Errorf("unexpected error: %v", entry.Error)
+			}
+		default:
+			t.Errorf("unexpected entry: %+v", entry)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing.png")); !os.IsNotExist(err) {
+		t.Errorf("error page should not be saved, got %v", err)
+	}
+}
+
+func TestResponseType(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		image       bool
+	}{
+		{"image", "image/png", png, true},
+		{"json", "application/json", []byte(`{"error": "not found"}`), false},
+		{"text", "text/plain", []byte("not found"), false},
+		{"xml", "application/xml", []byte("<error>not found</error>"), false},
+		{"sniffed image", "application/octet-stream", png, true},
+		{"sniffed text", "application/octet-stream", []byte("not found"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/" {
+					w.Header().Set("Content-Type", "text/html")
+					w.Write([]byte(`<html><body><img src="/pixel.png"></body></html>`))
+					return
+				}
+				w.Header().Set("Content-Type", test.contentType)
+				w.Write(test.body)
+			}))
+			defer server.Close()
+
+			feedback := make(chan downloader.DownloadEntry)
+			go downloader.DownloadImages(server.URL, t.TempDir(), feedback)
+
+			entries := collect(feedback)
+			if len(entries) != 1 {
+				t.Fatalf("expected single entry, got %+v", entries)
+			}
+			if test.image && entries[0].Error != nil {
+				t.Errorf("unexpected error: %v", entries[0].Error)
+			} else if !test.image && !errors.Is(entries[0].Error, downloader.ErrNotImage) {
+				t.Errorf("expected ErrNotImage, got %+v", entries[0])
+			}
+		})
+	}
+}
+
+func TestResume(t *testing.T) {
+	image := bytes.Repeat([]byte("0123456789"), 100)
+	tests := []struct {
+		name     string
+		partial  []byte
+		ranges   bool
+		skipped  bool
+		requests []string
+		// ETag of the partial file recorded by the previous run, the server
+		// sends another one
+		etag string
+	}{
+		{"partial", image[:300], true, false, []string{"bytes=300-"}, ""},
+		{"ranges not supported", image[:300], false, false, []string{"bytes=300-"}, ""},
+		{"complete", image, true, true, []string{"bytes=1000-"}, ""},
+		{"missing", nil, true, false, []string{""}, ""},
+		{"larger", append(image, "tail"...), true, false, []string{"bytes=1004-", ""}, ""},
+		{"changed", bytes.Repeat([]byte("x"), 300), true, false, []string{"bytes=300-"}, `"v1"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				ranges []string
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/":
+					w.Header().Set("Content-Type", "text/html")
+					w.Write([]byte(`<html><body><img src="/photo.png"></body></html>`))
+				case "/photo.png":
+					mu.Lock()
+					ranges = append(ranges, r.Header.Get("Range"))
+					mu.Unlock()
+					if test.ranges {
+						w.Header().Set("ETag", `"v2"`)
+						http.ServeContent(w, r, "photo.png", time.Time{}, bytes.NewReader(image))
+					} else {
+						w.Header().Set("Content-Type", "image/png")
+						w.Write(image)
+					}
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+			dir := t.TempDir()
+			if test.partial != nil {
+				if err := os.WriteFile(filepath.Join(dir, "photo.png"), test.partial, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(test.etag) > 0 {
+				manifest, _ := json.Marshal(downloader.Manifest{Images: []downloader.ManifestEntry{{
+					URL: server.URL + "/photo.png", Filename: filepath.Join(dir, "photo.png"),
+					ETag: test.etag,
+				}}})
+				if err := os.WriteFile(filepath.Join(dir, downloader.ManifestFilename), manifest,
+					0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config := downloader.DefaultConfig()
+			config.Resume = true
+			feedback := make(chan downloader.DownloadEntry)
+			go downloader.DownloadImagesWithConfig(server.URL, dir, config, feedback)
+
+			entries := collect(feedback)
+			if len(entries) != 1 || entries[0].Error != nil || entries[0].Skipped != test.skipped {
+				t.Fatalf("unexpected entries %+v", entries)
+			}
+			if expected := filepath.Join(dir, "photo.png"); entries[0].Filename != expected {
+				t.Errorf("saved %s, want %s", entries[0].Filename, expected)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "photo.png"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, image) {
+				t.Errorf("unexpected content %q", data)
+			}
+			if diff := cmp.Diff(test.requests, ranges); diff != "" {
+				t.Errorf("unexpected Range headers (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHTTPStatusErrorRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body><img src="/busy.png"></body></html>`))
+			return
+		}
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	config := downloader.DefaultConfig()
+	config.MaxAttempts = 1
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+	entries := collect(feedback)
+	var statusErr *downloader.HTTPStatusError
+	if len(entries) != 1 || !errors.As(entries[0].Error, &statusErr) {
+		t.Fatalf("expected HTTPStatusError, got %+v", entries)
+	}
+	if statusErr.Status != "429 Too Many Requests" || statusErr.Header.Get("Retry-After") != "120" {
+		t.Errorf("unexpected error %+v", statusErr)
+	}
+	if delay, found := statusErr.RetryAfter(); !found || delay != 2*time.Minute {
+		t.Errorf("RetryAfter() = %v, %v, want 2m", delay, found)
+	}
+}
+
+func TestFixExtensions(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body><img src="/photo.jpg"><img src="/icon.png"><img src="/vector.svg"></body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/photo.jpg": png, "/icon.png": png,
+		"/vector.svg": []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`),
+	})
+
+	config := downloader.DefaultConfig()
+	config.FixExtensions = true
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+	names := downloadedNames(t, collect(feedback))
+	expected := []string{"icon.png", "photo.png", "vector.svg"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	var leaked int32
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.Header.Get("Authorization")) > 0 {
+			atomic.AddInt32(&leaked, 1)
+		}
+		w.Write(png)
+	}))
+	defer cdn.Close()
+	server := newPageServer(t, `<html><body>
+		<img src="/pixel.png"><img src="`+cdn.URL+`/logo.png">
+	</body></html>`, map[string][]byte{"/pixel.png": png})
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		bearer := r.Header.Get("Authorization") == "Bearer secret-token"
+		if (!ok || user != "user" || password != "pass") && !bearer {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	basic := downloader.DefaultConfig()
+	basic.Username, basic.Password = "user", "pass"
+	token := downloader.DefaultConfig()
+	token.BearerToken = "secret-token"
+	for name, config := range map[string]downloader.Config{"basic": basic, "bearer": token} {
+		summary, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+			t.TempDir(), config, nil)
+		if err != nil || summary.Downloaded != 2 {
+			t.Errorf("%s: expected 2 downloads, got %+v (%v)", name, summary, err)
+		}
+	}
+	if n := atomic.LoadInt32(&leaked); n != 0 {
+		t.Errorf("credentials sent to the image host %d times", n)
+	}
+
+	_, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		t.TempDir(), downloader.DefaultConfig(), nil)
+	var statusErr *downloader.HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without credentials, got %v", err)
+	}
+}
+
+func TestResolveHosts(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body><img src="/pixel.png"><img src="http://cdn.example.test/logo.png"></body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/pixel.png": png, "/logo.png": png})
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+	config := downloader.DefaultConfig()
+	config.MaxAttempts = 1
+	config.ResolveHosts = map[string]string{
+		"staging.example.test": "127.0.0.1",
+		"cdn.example.test":     "127.0.0.1:" + port,
+	}
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig("http://staging.example.test:"+port, t.TempDir(),
+		config, feedback)
+
+	names := downloadedNames(t, collect(feedback))
+	if expected := []string{"logo.png", "pixel.png"}; !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+}
+
+func TestBlockPrivateNetworks(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	server := newPageServer(t, `<html><body><img src="/pixel.png"></body></html>`,
+		map[string][]byte{"/pixel.png": png})
+
+	config := downloader.DefaultConfig()
+	config.BlockPrivateNetworks = true
+	_, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		t.TempDir(), config, nil)
+	if !errors.Is(err, downloader.ErrPrivateAddress) {
+		t.Errorf("expected blocked page, got %v", err)
+	}
+
+	// untrusted page referencing internal address
+	page := `<html><body><img src="` + server.URL + `/pixel.png"></body></html>`
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesFromReaderWithConfig(strings.NewReader(page),
+		"https://example.com/", t.TempDir(), config, feedback)
+	entries := collect(feedback)
+	if len(entries) != 1 || !errors.Is(entries[0].Error, downloader.ErrPrivateAddress) {
+		t.Errorf("expected blocked image, got %+v", entries)
+	}
+}
+
+func TestCookies(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "page"})
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><img src="/pixel.png"></body></html>`))
+	})
+	mux.HandleFunc("/pixel.png", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "page" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write(png)
+	})
+	mux.HandleFunc("/private.html", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("token"); err != nil || cookie.Value != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body></body></html>`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	summary, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		t.TempDir(), downloader.DefaultConfig(), nil)
+	if err != nil || summary.Downloaded != 1 {
+		t.Errorf("expected cookie of the page to be reused, got %+v (%v)", summary, err)
+	}
+
+	config := downloader.DefaultConfig()
+	config.Cookies = []*http.Cookie{{Name: "token", Value: "secret"}}
+	if _, err := downloader.DownloadImagesWithSummary(context.Background(),
+		server.URL+"/private.html", t.TempDir(), config, nil); err != nil {
+		t.Errorf("expected configured cookie to be sent, got %v", err)
+	}
+}
+
+func TestOnProgress(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body><img src="/1.png"><img src="/2.png"><img src="/3.png"></body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/1.png": png, "/2.png": png, "/3.png": png})
+
+	var progress [][2]int
+	names := make(map[string]bool)
+	config := downloader.DefaultConfig()
+	config.OnProgress = func(done, total int, entry downloader.DownloadEntry) {
+		progress = append(progress, [2]int{done, total})
+		names[filepath.Base(entry.Filename)] = true
+	}
+	if _, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		t.TempDir(), config, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([][2]int{{1, 3}, {2, 3}, {3, 3}}, progress); diff != "" {
+		t.Errorf("unexpected progress (-want +got):\n%s", diff)
+	}
+	if len(names) != 3 {
+		t.Errorf("expected callback for every image, got %v", names)
+	}
+}
+
+func TestOnStart(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body><img src="/1.png"><img src="/2.png"><img src="/3.png"></body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/1.png": png, "/2.png": png, "/3.png": png})
+	dir := t.TempDir()
+
+	// calls are not concurrent
+	var events []string
+	config := downloader.DefaultConfig()
+	config.OnStart = func(entry downloader.DownloadEntry) {
+		events = append(events, "start "+entry.Filename)
+	}
+	config.OnProgress = func(done, total int, entry downloader.DownloadEntry) {
+		events = append(events, "done "+entry.Filename)
+	}
+	if _, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		dir, config, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 6 {
+		t.Fatalf("expected start and done events for every image, got %v", events)
+	}
+	for _, name := range []string{"1.png", "2.png", "3.png"} {
+		started, finished := -1, -1
+		for i, event := range events {
+			switch event {
+			case "start " + filepath.Join(dir, name):
+				started = i
+			case "done " + filepath.Join(dir, name):
+				finished = i
+			}
+		}
+		if started < 0 || finished < started {
+			t.Errorf("%s: expected start before done, got %v", name, events)
+		}
+	}
+}
+
+func TestOnFound(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body>
+		<img src="/1.png"><img src="/2.png"><img src="/1.png">
+		<img src="data:image/png;base64,` + pixelPNG + `">
+		<a href="/about.html">about</a>
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/1.png": png, "/2.png": png})
+
+	// calls are not concurrent
+	var events []string
+	config := downloader.DefaultConfig()
+	config.OnFound = func(total int) {
+		events = append(events, fmt.Sprintf("found %d", total))
+	}
+	config.OnStart = func(entry downloader.DownloadEntry) {
+		events = append(events, "start")
+	}
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+	entries := collect(feedback)
+
+	if len(events) != 4 || events[0] != "found 3" {
+		t.Errorf("expected 3 images found before downloads, got %v", events)
+	}
+	if len(entries) != 3 {
+		t.Errorf("expected 3 entries, got %+v", entries)
+	}
+}
+
+func TestPreservePaths(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body>
+		<img src="/assets/img/logo.png"><img src="/top.png">
+		<img src="/assets/%2e%2e/%2e%2e/escape.png">
+	</body></html>`
+	// ServeMux would redirect to the cleaned path
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(page))
+			return
+		}
+		w.Write(png)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+
+	config := downloader.DefaultConfig()
+	config.PreservePaths = true
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, dir, config, feedback)
+
+	var names []string
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			t.Errorf("unexpected error: %v", entry.Error)
+			continue
+		}
+		name, err := filepath.Rel(dir, entry.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, filepath.ToSlash(name))
+	}
+	sort.Strings(names)
+	expected := []string{"assets/escape.png", "assets/img/logo.png", "top.png"}
+	if diff := cmp.Diff(expected, names); diff != "" {
+		t.Errorf("unexpected files (-want +got):\n%s", diff)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "assets", "img", "logo.png")); err != nil {
+		t.Errorf("nested directories are not created: %v", err)
+	}
+}
+
+func TestHashNames(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body><img src="/first.png"><img src="/second.png"></body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/first.png": png, "/second.png": png})
+	dir := t.TempDir()
+
+	config := downloader.DefaultConfig()
+	config.HashNames = true
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, dir, config, feedback)
+
+	var downloaded, skipped int
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			t.Errorf("unexpected error: %v", entry.Error)
+		} else if entry.Skipped {
+			skipped++
+		} else {
+			downloaded++
+		}
+	}
+	if downloaded != 1 || skipped != 1 {
+		t.Errorf("expected 1 downloaded and 1 skipped, got %d and %d", downloaded, skipped)
+	}
+
+	sum := sha256.Sum256(png)
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 || files[0].Name() != hex.EncodeToString(sum[:])+".png" {
+		t.Errorf("expected single file named by content hash, got %v", files)
+	}
+}
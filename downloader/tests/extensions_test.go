@@ -1,6 +1,8 @@
 package downloader
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -65,3 +67,93 @@ func TestParseDataURL(t *testing.T) {
 		})
 	}
 }
+
+func TestParseDataURLErrors(t *testing.T) {
+	truncated := "data:image/png;base64" + strings.Repeat("A", 1000)
+	if _, err := downloader.ParseDataURL(truncated); err == nil {
+		t.Errorf("expected error for data URL without comma")
+	} else if len(err.Error()) > 200 {
+		t.Errorf("error repeats the whole URL: %d bytes", len(err.Error()))
+	}
+
+	oversized := "data:image/png;base64," +
+		strings.Repeat("A", downloader.MaxDataURLLength)
+	if _, err := downloader.ParseDataURL(oversized); !errors.Is(err, downloader.ErrDataURLTooLong) {
+		t.Errorf("expected ErrDataURLTooLong, got %v", err)
+	}
+}
+
+func TestDataURIDecode(t *testing.T) {
+	type testCase struct {
+		dataURL string
+		decoded string
+	}
+
+	var testCases = []testCase{
+		{"data:text/plain;base64,SGVsbG8sIFdvcmxkIQ==", "Hello, World!"},
+		{"data:,A%20brief%20note", "A brief note"},
+		{"data:image/svg+xml,%3Csvg%3E%3C/svg%3E", "<svg></svg>"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.dataURL, func(t *testing.T) {
+			data, err := downloader.ParseDataURL(test.dataURL)
+			if err != nil {
+				t.Fatalf("ParseDataURL failed: %v", err)
+			}
+			decoded, err := data.Decode()
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if string(decoded) != test.decoded {
+				t.Errorf("Decode() = %q, want %q", decoded, test.decoded)
+			}
+		})
+	}
+}
+
+func TestRegisterImageExtensions(t *testing.T) {
+	if downloader.IsImageExtension("foo") {
+		t.Fatalf("'foo' is recognized before registration")
+	}
+	downloader.RegisterImageExtensions(".FOO")
+	defer downloader.UnregisterImageExtensions("foo")
+
+	if !downloader.IsImageExtension("foo") {
+		t.Errorf("registered 'foo' is not recognized")
+	}
+	if !downloader.IsImageExtension("svg") {
+		t.Fatalf("'svg' is not recognized before removal")
+	}
+	downloader.UnregisterImageExtensions("svg")
+	defer downloader.RegisterImageExtensions("svg")
+
+	if downloader.IsImageExtension("svg") {
+		t.Errorf("removed 'svg' is still recognized")
+	}
+	for _, ext := range downloader.ImageExtensions() {
+		if ext == "svg" {
+			t.Errorf("removed 'svg' is listed in ImageExtensions")
+		}
+	}
+}
+
+func TestModernImageMimeTypes(t *testing.T) {
+	var testCases = map[string]string{
+		"image/avif": "avif",
+		"image/webp": "webp",
+		"image/jxl":  "jxl",
+		"image/heic": "heic",
+		"image/heif": "heif",
+	}
+
+	for mimeType, ext := range testCases {
+		exts, found := downloader.MimeTypeToExt[mimeType]
+		if !found || len(exts) == 0 || exts[0] != ext {
+			t.Errorf("MimeTypeToExt[%s] = %v, want %s", mimeType, exts, ext)
+		}
+		if !downloader.IsImageExtension(ext) {
+			t.Errorf("IsImageExtension(%s) = false", ext)
+		}
+	}
+}
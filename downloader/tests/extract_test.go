@@ -0,0 +1,157 @@
+package downloader
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"onethinglab.com/imagedown/downloader"
+)
+
+func TestExtractImages(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><head><link rel="icon" href="/favicon.ico"></head><body>
+		<img src="images/photo.jpg"><a href="https://cdn.example.com/full.gif">full</a>
+		<img src="data:image/png;base64,` + pixelPNG + `">
+		<div style="background-image: url(/bg.webp)"></div>
+		<img src="/images/photo.jpg">
+	</body></html>`
+
+	images, err := downloader.ExtractImages(strings.NewReader(page), "https://example.com/gallery/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []downloader.ImageRef{
+		{URL: "https://example.com/favicon.ico", ElementType: "<link>", Ext: "ico"},
+		{URL: "https://example.com/gallery/images/photo.jpg", ElementType: "<img>", Ext: "jpg"},
+		{URL: "https://cdn.example.com/full.gif", ElementType: "<a>", Ext: "gif"},
+		{ElementType: "<img>", Inline: true, Ext: "png", Data: png},
+		{URL: "https://example.com/bg.webp", ElementType: "style attribute", Ext: "webp"},
+		{URL: "https://example.com/images/photo.jpg", ElementType: "<img>", Ext: "jpg"},
+	}
+	if diff := cmp.Diff(expected, images); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+}
+
+func TestExtractImagesDocumentOrder(t *testing.T) {
+	page := `<html><body>
+		<div><p><img src="/1.png"></p><img src="/2.png"></div>
+		<img src="/3.png">
+		<section><div><div><a href="/4.png">4</a></div></div></section>
+		<img src="/5.png">
+	</body></html>`
+
+	images, err := downloader.ExtractImages(strings.NewReader(page), "https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var urls []string
+	for _, image := range images {
+		urls = append(urls, image.URL)
+	}
+	expected := []string{
+		"https://example.com/1.png", "https://example.com/2.png", "https://example.com/3.png",
+		"https://example.com/4.png", "https://example.com/5.png",
+	}
+	if diff := cmp.Diff(expected, urls); diff != "" {
+		t.Errorf("unexpected order (-want +got):\n%s", diff)
+	}
+}
+
+func TestExtractImagesNoscript(t *testing.T) {
+	page := `<html><body>
+		<noscript><img src="/real.jpg"><picture><source srcset="/real.webp"></picture></noscript>
+		<img src="/after.png">
+	</body></html>`
+
+	images, err := downloader.ExtractImages(strings.NewReader(page), "https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var urls []string
+	for _, image := range images {
+		urls = append(urls, image.URL)
+	}
+	expected := []string{
+		"https://example.com/real.jpg", "https://example.com/real.webp", "https://example.com/after.png",
+	}
+	if diff := cmp.Diff(expected, urls); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+}
+
+func TestExtractImagesInput(t *testing.T) {
+	page := `<html><body><form action="/search">
+		<input type="text" name="q" src="/ignored.png">
+		<input type="IMAGE" src="submit.png" alt="Search">
+		<input type="submit" value="Go">
+	</form></body></html>`
+
+	images, err := downloader.ExtractImages(strings.NewReader(page), "https://example.com/forms/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []downloader.ImageRef{
+		{URL: "https://example.com/forms/submit.png", ElementType: "<input>", Ext: "png"},
+	}
+	if diff := cmp.Diff(expected, images); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+}
+
+func TestExtractImagesPreload(t *testing.T) {
+	page := `<html><head>
+		<link rel="preload" as="image" href="/hero" type="image/webp"
+			imagesrcset="/hero-small 480w, /hero-large 1080w" imagesizes="100vw">
+		<link rel="preload" as="image" href="/banner.php">
+		<link rel="preload" as="script" href="/app.js">
+	</head><body></body></html>`
+
+	images, err := downloader.ExtractImages(strings.NewReader(page), "https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []downloader.ImageRef{
+		{URL: "https://example.com/hero-large", ElementType: "<link>", Ext: "webp"},
+		{URL: "https://example.com/banner.php", ElementType: "<link>"},
+	}
+	if diff := cmp.Diff(expected, images); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+}
+
+func TestExtractDataURLs(t *testing.T) {
+	page := `<html><head>
+		<style>body { background: url("data:image/gif;base64,R0lGODlh") }</style>
+	</head><body>
+		<img src="data:image/png;base64,` + pixelPNG + `" alt="data:not really">
+		<a href=" data:text/plain;charset=utf-8,Hello%20world ">note</a>
+		<div style="cursor: url(data:image/svg+xml,%3Csvg%3E%3C/svg%3E), auto"></div>
+		<a href="data:image/png;base64">malformed</a>
+		<img src="/photo.png" srcset="data:image/gif;base64,R0lGODdh 1x, data:image/png;base64,` + pixelPNG + ` 2x">
+	</body></html>`
+
+	dataURLs, err := downloader.ExtractDataURLs(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []downloader.DataURI{
+		{Type: "image", Subtype: "gif", IsBase64: true, Data: "R0lGODlh",
+			Params: map[string]string{}},
+		{Type: "image", Subtype: "png", IsBase64: true, Data: pixelPNG,
+			Params: map[string]string{}},
+		{Type: "text", Subtype: "plain", Data: "Hello%20world",
+			Params: map[string]string{"charset": "utf-8"}},
+		{Type: "image", Subtype: "svg+xml", Data: "%3Csvg%3E%3C/svg%3E",
+			Params: map[string]string{}},
+		{Type: "image", Subtype: "gif", IsBase64: true, Data: "R0lGODdh",
+			Params: map[string]string{}},
+		{Type: "image", Subtype: "png", IsBase64: true, Data: pixelPNG,
+			Params: map[string]string{}},
+	}
+	if diff := cmp.Diff(expected, dataURLs); diff != "" {
+		t.Errorf("unexpected data URLs (-want +got):\n%s", diff)
+	}
+}
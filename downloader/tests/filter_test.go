@@ -0,0 +1,249 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"onethinglab.com/imagedown/downloader"
+)
+
+// encodePNG return PNG image of the given size.
+func encodePNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// encodeGIF return GIF image with the given number of frames.
+func encodeGIF(t *testing.T, frames int) []byte {
+	animation := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White})
+		frame.SetColorIndex(0, 0, uint8(i%2))
+		animation.Image = append(animation.Image, frame)
+		animation.Delay = append(animation.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSkipAnimated(t *testing.T) {
+	page := `<html><body>
+		<img src="/animated.gif"><img src="/static.gif"><img src="/static.png">
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/animated.gif": encodeGIF(t, 3),
+		"/static.gif":   encodeGIF(t, 1),
+		"/static.png":   encodePNG(t, 2, 2),
+	})
+
+	config := downloader.DefaultConfig()
+	config.SkipAnimated = true
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesContext(context.Background(), server.URL, t.TempDir(),
+		config, feedback)
+
+	var downloaded, filtered []string
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			t.Errorf("unexpected error: %v", entry.Error)
+		} else if entry.Filtered {
+			filtered = append(filtered, entry.SourceURL)
+		} else {
+			downloaded = append(downloaded, filepath.Base(entry.Filename))
+		}
+	}
+	sort.Strings(downloaded)
+	if diff := cmp.Diff([]string{"static.gif", "static.png"}, downloaded); diff != "" {
+		t.Errorf("unexpected downloads (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{server.URL + "/animated.gif"}, filtered); diff != "" {
+		t.Errorf("unexpected filtered images (-want +got):\n%s", diff)
+	}
+}
+
+func TestMinDimensions(t *testing.T) {
+	page := `<html><body>
+		<img src="/small.png"><img src="/large.png">
+		<svg width="1" height="1"><rect width="1" height="1"></rect></svg>
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/small.png": encodePNG(t, 10, 300),
+		"/large.png": encodePNG(t, 300, 300),
+	})
+
+	config := downloader.DefaultConfig()
+	config.MinWidth, config.MinHeight = 200, 200
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesContext(context.Background(), server.URL, t.TempDir(),
+		config, feedback)
+
+	var downloaded, filtered int
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			t.Errorf("unexpected error: %v", entry.Error)
+		} else if entry.Filtered {
+			filtered++
+		} else {
+			downloaded++
+		}
+	}
+	// large PNG and SVG are kept
+	if downloaded != 2 || filtered != 1 {
+		t.Errorf("expected 2 downloaded and 1 filtered, got %d and %d", downloaded, filtered)
+	}
+}
+
+func TestURLFilters(t *testing.T) {
+	page := `<html><body>
+		<img src="http://cdn.example.com/photo.jpg">
+		<img src="http://cdn.example.com/photo-thumb.jpg">
+		<img src="http://static.example.com/logo.png">
+		<img src="/local.gif">
+	</body></html>`
+	server := newPageServer(t, page, nil)
+
+	config := downloader.DefaultConfig()
+	config.ListOnly = true
+	config.IncludeRegex = regexp.MustCompile(`^https?://cdn\.example\.com/`)
+	config.ExcludeRegex = regexp.MustCompile(`-thumb\.jpg$`)
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+	var urls []string
+	for _, entry := range collect(feedback) {
+		urls = append(urls, entry.SourceURL)
+	}
+	expected := []string{"http://cdn.example.com/photo.jpg"}
+	if diff := cmp.Diff(expected, urls); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+}
+
+func TestElementTypes(t *testing.T) {
+	page := `<html><head><link rel="icon" href="/favicon.ico"></head><body>
+		<img src="/photo.jpg"><a href="/full.jpg">full</a>
+		<picture><source srcset="/hero.webp" type="image/webp"><img src="/hero.jpg"></picture>
+	</body></html>`
+	server := newPageServer(t, page, nil)
+
+	config := downloader.DefaultConfig()
+	config.ListOnly = true
+	config.ElementTypes = []string{"<img>"}
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+	var urls []string
+	for _, entry := range collect(feedback) {
+		urls = append(urls, entry.SourceURL)
+	}
+	sort.Strings(urls)
+	expected := []string{server.URL + "/hero.jpg", server.URL + "/photo.jpg"}
+	if diff := cmp.Diff(expected, urls); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	page := `<html><head><link rel="icon" href="/favicon.ico"></head><body>
+		<img src="/photo.jpg"><a href="/full.jpg">full</a>
+		<picture><source srcset="/hero.webp" type="image/webp"><img src="/hero.jpg"></picture>
+		<iframe src="/banner.png"></iframe>
+	</body></html>`
+	server := newPageServer(t, page, nil)
+
+	config := downloader.DefaultConfig()
+	config.ListOnly = true
+	d := downloader.NewDownloader(downloader.WithConfig(config), downloader.WithHandlers("img"))
+	feedback, err := d.Download(context.Background(), server.URL, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var urls []string
+	for entry := range feedback {
+		urls = append(urls, entry.SourceURL)
+	}
+	sort.Strings(urls)
+	expected := []string{server.URL + "/hero.jpg", server.URL + "/photo.jpg"}
+	if diff := cmp.Diff(expected, urls); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+}
+
+func TestBlockedTypes(t *testing.T) {
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg"><rect width="1" height="1"/></svg>`)
+	page := `<html><body>
+		<svg width="1" height="1"><rect width="1" height="1"></rect></svg>
+		<a href="/logo.svg">logo</a><img src="/anim.gif"><img src="/photo.png">
+		<img src="/image.php">
+	</body></html>`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(page))
+	})
+	mux.HandleFunc("/photo.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(encodePNG(t, 1, 1))
+	})
+	// the URL does not tell the type
+	mux.HandleFunc("/image.php", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(svg)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := downloader.DefaultConfig()
+	// empty entry must not block images without extension
+	config.BlockedTypes = []string{".SVG", "image/gif", ""}
+	feedback := make(chan downloader.DownloadEntry)
+	var summary downloader.Summary
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		summary, _ = downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+			t.TempDir(), config, feedback)
+	}()
+
+	var downloaded, filtered []string
+	for entry := range feedback {
+		if entry.Error != nil {
+			t.Errorf("unexpected error: %v", entry.Error)
+		} else if entry.Filtered {
+			filtered = append(filtered, entry.SourceURL)
+		} else {
+			downloaded = append(downloaded, entry.SourceURL)
+		}
+	}
+	<-done
+	sort.Strings(filtered)
+	if diff := cmp.Diff([]string{server.URL + "/photo.png"}, downloaded); diff != "" {
+		t.Errorf("unexpected downloads (-want +got):\n%s", diff)
+	}
+	// the inline <svg> has no source URL
+	expected := []string{"", server.URL + "/anim.gif", server.URL + "/image.php",
+		server.URL + "/logo.svg"}
+	if diff := cmp.Diff(expected, filtered); diff != "" {
+		t.Errorf("unexpected filtered images (-want +got):\n%s", diff)
+	}
+	if summary.Filtered != len(expected) || summary.Downloaded != 1 {
+		t.Errorf("unexpected counts in summary: %+v", summary)
+	}
+}
@@ -0,0 +1,220 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"onethinglab.com/imagedown/downloader"
+)
+
+func TestManifest(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><head><link rel="icon" href="/favicon.png"></head><body>
+		<img src="/photo.png"><img src="/missing.png">
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/favicon.png": png, "/photo.png": png})
+	dir := t.TempDir()
+
+	config := downloader.DefaultConfig()
+	config.WriteManifest = true
+	_, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL, dir,
+		config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, downloader.ManifestFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest downloader.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+
+	if manifest.Page != server.URL {
+		t.Errorf("manifest.Page = %q, want %q", manifest.Page, server.URL)
+	}
+	if len(manifest.Images) != 3 {
+		t.Fatalf("expected 3 images in manifest, got %+v", manifest.Images)
+	}
+	byURL := make(map[string]downloader.ManifestEntry)
+	for _, image := range manifest.Images {
+		byURL[image.URL] = image
+	}
+
+	favicon := byURL[server.URL+"/favicon.png"]
+	if favicon.Element != "<link>" || favicon.Bytes != int64(len(png)) ||
+		favicon.Filename != filepath.Join(dir, "favicon.png") || len(favicon.Error) > 0 {
+		t.Errorf("unexpected favicon entry: %+v", favicon)
+	}
+	photo := byURL[server.URL+"/photo.png"]
+	if photo.Element != "<img>" || photo.Filename != filepath.Join(dir, "photo.png") {
+		t.Errorf("unexpected photo entry: %+v", photo)
+	}
+	missing := byURL[server.URL+"/missing.png"]
+	if missing.Element != "<img>" || len(missing.Error) == 0 || len(missing.Filename) > 0 {
+		t.Errorf("unexpected missing image entry: %+v", missing)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	changed := encodePNG(t, 2, 2)
+	var (
+		notModified int32
+		etag        atomic.Value
+	)
+	etag.Store(`"v1"`)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><img src="/photo.png"></body></html>`))
+	})
+	mux.HandleFunc("/photo.png", func(w http.ResponseWriter, r *http.Request) {
+		current := etag.Load().(string)
+		if r.Header.Get("If-None-Match") == current {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", current)
+		if current == `"v1"` {
+			w.Write(png)
+		} else {
+			w.Write(changed)
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	dir := t.TempDir()
+
+	config := downloader.DefaultConfig()
+	config.ConditionalRequests = true
+	for run, expected := range []downloader.Summary{{Found: 1, Downloaded: 1}, {Found: 1, Skipped: 1},
+		{Found: 1, Skipped: 1}} {
+		summary, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+			dir, config, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		summary.Bytes, summary.Elapsed = 0, 0
+		if summary != expected {
+			t.Errorf("run %d: unexpected summary %+v", run+1, summary)
+		}
+	}
+	if n := atomic.LoadInt32(&notModified); n != 2 {
+		t.Errorf("expected 2 not modified responses, got %d", n)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "photo.png")); err != nil || !bytes.Equal(data, png) {
+		t.Errorf("image is not kept: %v", err)
+	}
+
+	// the changed image replaces the file of the previous run
+	etag.Store(`"v2"`)
+	summary, err := downloader.DownloadImagesWithSummary(context.Background(), server.URL,
+		dir, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Downloaded != 1 {
+		t.Errorf("changed image is not downloaded: %+v", summary)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "photo.png")); err != nil || !bytes.Equal(data, changed) {
+		t.Errorf("image is not updated: %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "photo*")); len(files) != 1 {
+		t.Errorf("expected single image file, got %v", files)
+	}
+}
+
+func TestMaxInlineBytes(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body><img src="data:image/png;base64,` + pixelPNG + `"></body></html>`
+	server := newPageServer(t, page, nil)
+
+	tests := []struct {
+		name     string
+		maxBytes int64
+		recorded bool
+	}{
+		{"equal", int64(len(png)), false},
+		{"larger", int64(len(png)) - 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := downloader.DefaultConfig()
+			config.MaxInlineBytes = test.maxBytes
+			feedback := make(chan downloader.DownloadEntry)
+			go downloader.DownloadImagesWithConfig(server.URL, dir, config, feedback)
+
+			entries := collect(feedback)
+			if len(entries) != 1 || entries[0].Error != nil {
+				t.Fatalf("expected single image, got %+v", entries)
+			}
+			files, _ := filepath.Glob(filepath.Join(dir, "*.png"))
+			if saved := len(files) == 1; saved == test.recorded {
+				t.Errorf("saved files %v, recorded in manifest %v", files, test.recorded)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, downloader.ManifestFilename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var manifest downloader.Manifest
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				t.Fatalf("invalid manifest: %v", err)
+			}
+			image := manifest.Images[0]
+			if test.recorded {
+				if expected := "data:image/png;base64," + pixelPNG; image.DataURL != expected ||
+					len(image.Filename) > 0 {
+					t.Errorf("unexpected manifest entry: %+v", image)
+				}
+				if expected := filepath.Join(dir, downloader.ManifestFilename); entries[0].Filename != expected {
+					t.Errorf("entry filename %s, want %s", entries[0].Filename, expected)
+				}
+			} else if len(image.DataURL) > 0 || image.Filename != entries[0].Filename {
+				t.Errorf("unexpected manifest entry: %+v", image)
+			}
+		})
+	}
+}
+
+func TestElementTypeReported(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><head><link rel="icon" href="/favicon.png"></head><body></body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/favicon.png": png})
+	dir := t.TempDir()
+
+	config := downloader.DefaultConfig()
+	config.WriteManifest = true
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, dir, config, feedback)
+
+	entries := collect(feedback)
+	if len(entries) != 1 || entries[0].Error != nil || entries[0].ElementType != "<link>" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, downloader.ManifestFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest downloader.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	if len(manifest.Images) != 1 || manifest.Images[0].Element != "<link>" {
+		t.Errorf("unexpected manifest images: %+v", manifest.Images)
+	}
+}
@@ -0,0 +1,113 @@
+package downloader
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"onethinglab.com/imagedown/downloader"
+)
+
+func TestNewDownloader(t *testing.T) {
+	base := downloader.DefaultConfig()
+	base.MinBytes = 10
+	d := downloader.NewDownloader(
+		downloader.WithConfig(base),
+		downloader.WithTimeout(5*time.Second),
+		downloader.WithConcurrency(2),
+		downloader.WithTLSVerify(false),
+		downloader.WithHeader("Referer", "https://example.com/"),
+	)
+
+	config := d.Config()
+	if config.Timeout != 5*time.Second || config.Concurrency != 2 ||
+		!config.InsecureSkipVerify || config.MinBytes != 10 {
+		t.Errorf("options are not applied: %+v", config)
+	}
+	expected := map[string]string{"Referer": "https://example.com/"}
+	if diff := cmp.Diff(expected, config.Headers); diff != "" {
+		t.Errorf("unexpected headers (-want +got):\n%s", diff)
+	}
+}
+
+func TestDownloaderDownload(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body><img src="/one.png"><img src="/two.png"></body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/one.png": png, "/two.png": png})
+	var withUserAgent int32
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.UserAgent() == "imagedown-test" {
+			atomic.AddInt32(&withUserAgent, 1)
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	d := downloader.NewDownloader(downloader.WithUserAgent("imagedown-test"),
+		downloader.WithConcurrency(1))
+	if _, err := d.Download(context.Background(), "/relative", t.TempDir()); err == nil {
+		t.Errorf("expected error for relative page URL")
+	}
+
+	feedback, err := d.Download(context.Background(), server.URL, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []downloader.DownloadEntry
+	for entry := range feedback {
+		entries = append(entries, entry)
+	}
+	names := downloadedNames(t, entries)
+	if expected := []string{"one.png", "two.png"}; !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+	// the page, robots.txt and both images
+	if n := atomic.LoadInt32(&withUserAgent); n != 4 {
+		t.Errorf("expected 4 requests with User-Agent, got %d", n)
+	}
+}
+
+// cannedTransport serve responses by URL without network access.
+type cannedTransport map[string]string
+
+func (c cannedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found",
+		Header: make(http.Header), Body: io.NopCloser(strings.NewReader("")), Request: req}
+	if body, found := c[req.URL.String()]; found {
+		resp.StatusCode, resp.Status = http.StatusOK, "200 OK"
+		resp.Header.Set("Content-Type", http.DetectContentType([]byte(body)))
+		resp.Body = io.NopCloser(strings.NewReader(body))
+	}
+	return resp, nil
+}
+
+func TestWithTransport(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	transport := cannedTransport{
+		"http://example.test/": `<html><body>
+			<img src="/photo.png"><img src="http://cdn.example.test/logo.png">
+		</body></html>`,
+		"http://example.test/photo.png":    string(png),
+		"http://cdn.example.test/logo.png": string(png),
+	}
+
+	d := downloader.NewDownloader(downloader.WithTransport(transport))
+	feedback, err := d.Download(context.Background(), "http://example.test/", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []downloader.DownloadEntry
+	for entry := range feedback {
+		entries = append(entries, entry)
+	}
+	names := downloadedNames(t, entries)
+	if expected := []string{"logo.png", "photo.png"}; !cmp.Equal(names, expected) {
+		t.Errorf("downloaded %v, want %v", names, expected)
+	}
+}
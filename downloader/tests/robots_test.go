@@ -0,0 +1,151 @@
+package downloader
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"onethinglab.com/imagedown/downloader"
+)
+
+func TestRobots(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	var privateRequests int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`# rules for all crawlers
+User-agent: *
+Disallow: /private/
+Allow: /private/public.png
+
+User-agent: imagedown
+Disallow: /
+`))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body>
+			<img src="/private/secret.png"><img src="/private/public.png"><img src="/open.png">
+		</body></html>`))
+	})
+	mux.HandleFunc("/private/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&privateRequests, 1)
+		w.Write(png)
+	})
+	mux.HandleFunc("/open.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(png)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	run := func(config downloader.Config) []downloader.DownloadEntry {
+		feedback := make(chan downloader.DownloadEntry)
+		go downloader.DownloadImagesContext(context.Background(), server.URL, t.TempDir(),
+			config, feedback)
+		return collect(feedback)
+	}
+
+	t.Run("wildcard agent", func(t *testing.T) {
+		atomic.StoreInt32(&privateRequests, 0)
+		var downloaded int
+		for _, entry := range run(downloader.DefaultConfig()) {
+			if entry.Error == nil {
+				downloaded++
+			} else if entry.SourceURL != server.URL+"/private/secret.png" ||
+				!errors.Is(entry.Error, downloader.ErrDisallowedByRobots) {
+				t.Errorf("unexpected error: %v", entry.Error)
+			}
+		}
+		if downloaded != 2 || privateRequests != 1 {
+			t.Errorf("expected 2 downloads and 1 private request, got %d and %d",
+				downloaded, privateRequests)
+		}
+	})
+
+	t.Run("matching agent", func(t *testing.T) {
+		config := downloader.DefaultConfig()
+		config.UserAgent = "imagedown/1.0"
+		entries := run(config)
+		if len(entries) != 1 || !errors.Is(entries[0].Error, downloader.ErrDisallowedByRobots) {
+			t.Errorf("expected page to be disallowed, got %+v", entries)
+		}
+	})
+
+	t.Run("blank agent", func(t *testing.T) {
+		config := downloader.DefaultConfig()
+		config.UserAgent = "   "
+		var downloaded int
+		for _, entry := range run(config) {
+			if entry.Error == nil {
+				downloaded++
+			}
+		}
+		if downloaded != 2 {
+			t.Errorf("expected wildcard rules to apply, got %d downloads", downloaded)
+		}
+	})
+
+	t.Run("ignored", func(t *testing.T) {
+		atomic.StoreInt32(&privateRequests, 0)
+		config := downloader.DefaultConfig()
+		config.IgnoreRobots = true
+		for _, entry := range run(config) {
+			if entry.Error != nil {
+				t.Errorf("unexpected error: %v", entry.Error)
+			}
+		}
+		if privateRequests != 2 {
+			t.Errorf("expected 2 private requests, got %d", privateRequests)
+		}
+	})
+}
+
+func TestRobotsGroups(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	var robots string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(robots))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><img src="/pixel.png"></body></html>`))
+	})
+	mux.HandleFunc("/pixel.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(png)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		name, robots string
+		disallowed   bool
+	}{
+		{"product token ignoring case", "User-agent: ImageDown\nDisallow: /\n", true},
+		{"product token with version", "User-agent: imagedown/2.0\nDisallow: /\n", true},
+		{"other product containing token", "User-agent: image\nDisallow: /\n", false},
+		{"empty agent", "User-agent:\nDisallow: /\n\nUser-agent: *\nAllow: /\n", false},
+		{"specific group over wildcard", "User-agent: *\nDisallow: /\n\nUser-agent: imagedown\nAllow: /\n", false},
+		{"wildcard without specific group", "User-agent: other\nAllow: /\n\nUser-agent: *\nDisallow: /\n", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			robots = test.robots
+			config := downloader.DefaultConfig()
+			config.UserAgent = "imagedown/1.0 (+https://example.com)"
+			feedback := make(chan downloader.DownloadEntry)
+			go downloader.DownloadImagesContext(context.Background(), server.URL, t.TempDir(),
+				config, feedback)
+
+			entries := collect(feedback)
+			disallowed := len(entries) == 1 && errors.Is(entries[0].Error, downloader.ErrDisallowedByRobots)
+			if disallowed != test.disallowed {
+				t.Errorf("expected disallowed %v, got %+v", test.disallowed, entries)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package downloader
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"onethinglab.com/imagedown/downloader"
+)
+
+// memorySink collects stored files into a map.
+type memorySink struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+type memoryFile struct {
+	bytes.Buffer
+	name string
+	sink *memorySink
+}
+
+func (f *memoryFile) Close() error {
+	f.sink.mu.Lock()
+	defer f.sink.mu.Unlock()
+	f.sink.files[f.name] = f.Bytes()
+	return nil
+}
+
+func (s *memorySink) Create(name string) (io.WriteCloser, error) {
+	return &memoryFile{name: name, sink: s}, nil
+}
+
+func (s *memorySink) Exists(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, found := s.files[name]
+	return found
+}
+
+func TestMemorySink(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body>
+		<img src="/a/pixel.png"><img src="/b/pixel.png">
+		<img src="data:image/png;base64,` + pixelPNG + `">
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{"/a/pixel.png": png, "/b/pixel.png": png})
+	dir := t.TempDir()
+
+	sink := &memorySink{files: make(map[string][]byte)}
+	config := downloader.DefaultConfig()
+	config.Sink = sink
+	config.WriteManifest = true
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, dir, config, feedback)
+
+	var names []string
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			t.Fatalf("unexpected error: %v", entry.Error)
+		}
+		names = append(names, entry.Filename)
+	}
+
+	if len(names) != 3 {
+		t.Fatalf("expected 3 images, got %v", names)
+	}
+	for _, name := range names {
+		if !bytes.Equal(sink.files[name], png) {
+			t.Errorf("%s is not stored in the sink", name)
+		}
+	}
+	if _, found := sink.files[downloader.ManifestFilename]; !found {
+		t.Errorf("manifest is not stored in the sink")
+	}
+	var remote []string
+	for _, name := range names {
+		if strings.HasPrefix(name, "pixel") {
+			remote = append(remote, name)
+		}
+	}
+	sort.Strings(remote)
+	if diff := cmp.Diff([]string{"pixel (1).png", "pixel.png"}, remote); diff != "" {
+		t.Errorf("unexpected names in the sink (-want +got):\n%s", diff)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected no files in the directory, got %d", len(files))
+	}
+}
+
+func TestZipSink(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	page := `<html><body>
+		<img src="/a/logo.png"><img src="/b/logo.png"><img src="/photo.jpg">
+	</body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/a/logo.png": png, "/b/logo.png": png, "/photo.jpg": png,
+	})
+
+	var archive bytes.Buffer
+	sink := downloader.NewZipSink(&archive)
+	config := downloader.DefaultConfig()
+	config.Sink = sink
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+	var names []string
+	for _, entry := range collect(feedback) {
+		if entry.Error != nil {
+			t.Fatalf("unexpected error: %v", entry.Error)
+		}
+		names = append(names, entry.Filename)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	for _, file := range reader.File {
+		entries = append(entries, file.Name)
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(data, png) {
+			t.Errorf("%s: unexpected content (%v)", file.Name, err)
+		}
+	}
+	sort.Strings(names)
+	sort.Strings(entries)
+	expected := []string{"logo (1).png", "logo.png", "photo.jpg"}
+	if diff := cmp.Diff(expected, entries); diff != "" {
+		t.Errorf("unexpected archive entries (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(expected, names); diff != "" {
+		t.Errorf("unexpected entry file names (-want +got):\n%s", diff)
+	}
+}
+
+func TestZipSinkDiscardsFailedFiles(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	server := newPageServer(t, `<html><body><img src="/ok.png"><img src="/broken.png"></body></html>`,
+		map[string][]byte{"/ok.png": png})
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/broken.png" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		// the connection is closed before the promised length is sent
+		w.Header().Set("Content-Length", strconv.Itoa(len(png)*2))
+		w.Write(png)
+	})
+
+	var archive bytes.Buffer
+	sink := downloader.NewZipSink(&archive)
+	config := downloader.DefaultConfig()
+	config.Sink = sink
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+	for _, entry := range collect(feedback) {
+		if failed := entry.Error != nil; failed != strings.HasSuffix(entry.SourceURL, "/broken.png") {
+			t.Errorf("unexpected entry %+v", entry)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	for _, file := range reader.File {
+		entries = append(entries, file.Name)
+	}
+	if expected := []string{"ok.png"}; !cmp.Equal(entries, expected) {
+		t.Errorf("archive entries %v, want %v", entries, expected)
+	}
+}
+
+func TestMemorySinkHashNamesMaxTotalBytes(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	other := append(append([]byte{}, png...), 0)
+	page := `<html><body><img src="/a.png"><img src="/b.png"><img src="/large.png"></body></html>`
+	server := newPageServer(t, page, map[string][]byte{
+		"/a.png": png, "/b.png": other, "/large.png": bytes.Repeat([]byte{0xff}, 10000),
+	})
+
+	sink := &memorySink{files: make(map[string][]byte)}
+	config := downloader.DefaultConfig()
+	config.Sink = sink
+	config.HashNames = true
+	config.Concurrency = 1
+	// buffered images must not be counted twice
+	config.MaxTotalBytes = int64(len(png)+len(other)) + 1
+	feedback := make(chan downloader.DownloadEntry)
+	go downloader.DownloadImagesWithConfig(server.URL, t.TempDir(), config, feedback)
+
+	for _, entry := range collect(feedback) {
+		large := strings.HasSuffix(entry.SourceURL, "/large.png")
+		if entry.Error != nil && !errors.Is(entry.Error, downloader.ErrTotalBytesExceeded) ||
+			entry.SourceURL != "" && (entry.Error != nil) != large {
+			t.Errorf("unexpected entry %+v", entry)
+		}
+	}
+	if len(sink.files) != 2 {
+		t.Errorf("expected only the images within the limit in the sink, got %d files", len(sink.files))
+	}
+	for name, data := range sink.files {
+		if len(data) > len(other) {
+			t.Errorf("%s of %d bytes is stored over the limit", name, len(data))
+		}
+	}
+}
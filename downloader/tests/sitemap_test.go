@@ -0,0 +1,128 @@
+package downloader
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"onethinglab.com/imagedown/downloader"
+)
+
+func TestDownloadImagesFromSitemap(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	documents := map[string]string{
+		"/sitemap.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>/sitemap-pages.xml</loc></sitemap>
+  <sitemap><loc>/sitemap-missing.xml</loc></sitemap>
+</sitemapindex>`,
+		"/sitemap-pages.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>/first.html</loc></url>
+  <url><loc> /second.html </loc><lastmod>2021-01-01</lastmod></url>
+</urlset>`,
+		"/first.html":  `<html><body><img src="/one.png"></body></html>`,
+		"/second.html": `<html><body><img src="/two.png"><img src="/one.png"></body></html>`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if document, found := documents[r.URL.Path]; found {
+			w.Write([]byte(document))
+			return
+		}
+		if r.URL.Path == "/one.png" || r.URL.Path == "/two.png" {
+			w.Write(png)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	feedback := make(chan downloader.DownloadEntry)
+	done := make(chan []downloader.DownloadEntry)
+	go func() { done <- collect(feedback) }()
+	summary, err := downloader.DownloadImagesFromSitemap(context.Background(),
+		server.URL+"/sitemap.xml", t.TempDir(), downloader.DefaultConfig(), feedback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Found != 2 || summary.Downloaded != 2 {
+		t.Errorf("expected 2 found and downloaded images, got %+v", summary)
+	}
+	var images []downloader.DownloadEntry
+	for _, entry := range <-done {
+		if entry.SourceURL == server.URL+"/sitemap-missing.xml" {
+			if entry.Error == nil {
+				t.Errorf("expected error for missing sitemap")
+			}
+			continue
+		}
+		images = append(images, entry)
+	}
+	if names := downloadedNames(t, images); !cmp.Equal(names, []string{"one.png", "two.png"}) {
+		t.Errorf("downloaded %v", names)
+	}
+
+	config := downloader.DefaultConfig()
+	config.Limit = 1
+	summary, err = downloader.DownloadImagesFromSitemap(context.Background(),
+		server.URL+"/sitemap.xml", t.TempDir(), config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Found != 2 || summary.Downloaded != 1 {
+		t.Errorf("expected 2 found and 1 downloaded images, got %+v", summary)
+	}
+
+	_, err = downloader.DownloadImagesFromSitemap(context.Background(),
+		server.URL+"/sitemap-missing.xml", t.TempDir(), config, nil)
+	if err == nil {
+		t.Errorf("expected error for missing sitemap")
+	}
+}
+
+func TestSitemapMaxPageBytes(t *testing.T) {
+	index := `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>/sitemap-large.xml</loc></sitemap>
+</sitemapindex>`
+	large := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` +
+		strings.Repeat("<url><loc>/page.html</loc></url>", 1000) + `</urlset>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			w.Write([]byte(index))
+		case "/sitemap-large.xml":
+			w.Write([]byte(large))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	config := downloader.DefaultConfig()
+	config.MaxPageBytes = int64(len(index))
+	feedback := make(chan downloader.DownloadEntry)
+	done := make(chan []downloader.DownloadEntry)
+	go func() { done <- collect(feedback) }()
+	if _, err := downloader.DownloadImagesFromSitemap(context.Background(),
+		server.URL+"/sitemap.xml", t.TempDir(), config, feedback); err != nil {
+		t.Fatal(err)
+	}
+	entries := <-done
+	if len(entries) != 1 || entries[0].SourceURL != server.URL+"/sitemap-large.xml" ||
+		!errors.Is(entries[0].Error, downloader.ErrPageTooLarge) {
+		t.Errorf("expected the indexed sitemap to exceed the limit, got %+v", entries)
+	}
+
+	_, err := downloader.DownloadImagesFromSitemap(context.Background(),
+		server.URL+"/sitemap-large.xml", t.TempDir(), config, nil)
+	if !errors.Is(err, downloader.ErrPageTooLarge) {
+		t.Errorf("expected ErrPageTooLarge, got %v", err)
+	}
+}
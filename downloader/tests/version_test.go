@@ -0,0 +1,31 @@
+package downloader
+
+import (
+	"testing"
+
+	"onethinglab.com/imagedown/downloader"
+)
+
+func TestVersion(t *testing.T) {
+	defer func(version, commit, date string) {
+		downloader.BuildVersion, downloader.BuildCommit, downloader.BuildDate = version, commit, date
+	}(downloader.BuildVersion, downloader.BuildCommit, downloader.BuildDate)
+
+	downloader.BuildVersion, downloader.BuildCommit, downloader.BuildDate = "", "", ""
+	if version := downloader.Version(); len(version) == 0 {
+		t.Errorf("expected version without injected value")
+	}
+
+	downloader.BuildVersion = "v1.2.0"
+	if version := downloader.Version(); version != "v1.2.0" {
+		t.Errorf("Version() = %q, want injected v1.2.0", version)
+	}
+	if info := downloader.VersionInfo(); info != "v1.2.0" {
+		t.Errorf("VersionInfo() = %q, want v1.2.0", info)
+	}
+
+	downloader.BuildCommit, downloader.BuildDate = "3002c90", "2021-05-01"
+	if info, expected := downloader.VersionInfo(), "v1.2.0 (commit 3002c90, built 2021-05-01)"; info != expected {
+		t.Errorf("VersionInfo() = %q, want %q", info, expected)
+	}
+}
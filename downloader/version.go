@@ -0,0 +1,60 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// version.go implements:
+//  - Reporting version of the build.
+
+package downloader
+
+import (
+	"runtime/debug"
+	"strings"
+)
+
+// Build information injected by the linker, e.g.
+//
+//	go build -ldflags "-X onethinglab.com/imagedown/downloader.BuildVersion=v1.2.0
+//	  -X onethinglab.com/imagedown/downloader.BuildCommit=$(git rev-parse HEAD)
+//	  -X onethinglab.com/imagedown/downloader.BuildDate=$(date -u +%F)"
+var (
+	BuildVersion string
+	BuildCommit  string
+	BuildDate    string
+)
+
+// Version return BuildVersion, or the module version when the binary is
+// built with "go install module@version", "(devel)" otherwise.
+func Version() string {
+	if len(BuildVersion) > 0 {
+		return BuildVersion
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && len(info.Main.Version) > 0 {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// VersionInfo return Version with commit and build date when they are known,
+// e.g. "v1.2.0 (commit 3002c90, built 2021-05-01)". Values which are not
+// injected by the linker are taken from version control information stamped
+// by the go command, if any.
+func VersionInfo() string {
+	commit, date := vcsInfo()
+	if len(BuildCommit) > 0 {
+		commit = BuildCommit
+	}
+	if len(BuildDate) > 0 {
+		date = BuildDate
+	}
+
+	var details []string
+	if len(commit) > 0 {
+		details = append(details, "commit "+commit)
+	}
+	if len(date) > 0 {
+		details = append(details, "built "+date)
+	}
+	if len(details) == 0 {
+		return Version()
+	}
+	return Version() + " (" + strings.Join(details, ", ") + ")"
+}
@@ -0,0 +1,15 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// version_novcs.go implements:
+//  - Version control information for toolchains which don't stamp it.
+
+//go:build !go1.18
+// +build !go1.18
+
+package downloader
+
+// vcsInfo return nothing, Go before 1.18 doesn't stamp the binary with
+// version control information.
+func vcsInfo() (commit, date string) {
+	return "", ""
+}
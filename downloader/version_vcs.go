@@ -0,0 +1,52 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// version_vcs.go implements:
+//  - Reading version control information stamped into the binary.
+//
+// debug.BuildInfo.Settings exists since Go 1.18, while the module supports
+// Go 1.16, so older toolchains build version_novcs.go instead.
+
+//go:build go1.18
+// +build go1.18
+
+package downloader
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+// vcsInfo return the commit and its date stamped by the go command when the
+// binary is built inside a repository.
+func vcsInfo() (commit, date string) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "", ""
+	}
+	return vcsSettings(info.Settings)
+}
+
+// vcsSettings return the short revision, marked "-dirty" for uncommitted
+// changes, and the commit date from `settings`.
+func vcsSettings(settings []debug.BuildSetting) (commit, date string) {
+	var modified bool
+	for _, setting := range settings {
+		switch setting.Key {
+		case "vcs.revision":
+			commit = setting.Value
+			if len(commit) > 7 {
+				commit = commit[:7]
+			}
+		case "vcs.time":
+			if t, err := time.Parse(time.RFC3339, setting.Value); err == nil {
+				date = t.UTC().Format("2006-01-02")
+			}
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if modified && len(commit) > 0 {
+		commit += "-dirty"
+	}
+	return commit, date
+}
@@ -0,0 +1,39 @@
+//go:build go1.18
+// +build go1.18
+
+package downloader
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+// vcsSettings is unexported, go test doesn't stamp version control
+// information, so the fallback is tested from inside the package.
+func TestVCSSettings(t *testing.T) {
+	tests := []struct {
+		settings     []debug.BuildSetting
+		commit, date string
+	}{
+		{nil, "", ""},
+		{[]debug.BuildSetting{
+			{Key: "vcs", Value: "git"},
+			{Key: "vcs.revision", Value: "3002c90a6e1b4c0a2f6f7f1d8e5b9a0c4d3e2f10"},
+			{Key: "vcs.time", Value: "2021-05-01T22:30:00+03:00"},
+			{Key: "vcs.modified", Value: "false"},
+		}, "3002c90", "2021-05-01"},
+		{[]debug.BuildSetting{
+			{Key: "vcs.revision", Value: "3002c90a6e1b4c0a2f6f7f1d8e5b9a0c4d3e2f10"},
+			{Key: "vcs.time", Value: "2021-05-01T22:30:00-03:00"},
+			{Key: "vcs.modified", Value: "true"},
+		}, "3002c90-dirty", "2021-05-02"},
+		{[]debug.BuildSetting{{Key: "vcs.time", Value: "yesterday"}}, "", ""},
+	}
+	for _, test := range tests {
+		commit, date := vcsSettings(test.settings)
+		if commit != test.commit || date != test.date {
+			t.Errorf("vcsSettings(%v) = %q, %q, want %q, %q",
+				test.settings, commit, date, test.commit, test.date)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2021 Bagrii Petro.
+//
+// zip.go implements:
+//  - Storing images in a zip archive.
+
+package downloader
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ZipSink stores images in a zip archive. Files are kept in memory until they
+// are closed, so concurrent downloads do not interleave in the archive, files
+// which failed to be written are discarded. Close must be called once
+// downloading is finished to complete the archive.
+type ZipSink struct {
+	mu     sync.Mutex
+	writer *zip.Writer
+	// names of the stored entries
+	names map[string]bool
+}
+
+// NewZipSink return sink writing zip archive into `w`.
+func NewZipSink(w io.Writer) *ZipSink {
+	return &ZipSink{writer: zip.NewWriter(w), names: make(map[string]bool)}
+}
+
+type zipFile struct {
+	bytes.Buffer
+	name string
+	sink *ZipSink
+}
+
+// Close add the file to the archive.
+func (f *zipFile) Close() error {
+	return f.sink.add(f.name, f.Bytes())
+}
+
+// Abort discard the file, it is not added to the archive.
+func (f *zipFile) Abort() {
+	f.Reset()
+}
+
+func (z *ZipSink) add(name string, data []byte) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	// entries can not be replaced
+	if z.names[name] {
+		return fmt.Errorf("%s: already in the archive", name)
+	}
+	entry, err := z.writer.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := entry.Write(data); err != nil {
+		return err
+	}
+	z.names[name] = true
+	return nil
+}
+
+// Create return the file added to the archive on Close.
+func (z *ZipSink) Create(name string) (io.WriteCloser, error) {
+	return &zipFile{name: name, sink: z}, nil
+}
+
+// Exists return whether the file is in the archive.
+func (z *ZipSink) Exists(name string) bool {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.names[name]
+}
+
+// Close write the archive directory, the underlying writer is not closed.
+func (z *ZipSink) Close() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.writer.Close()
+}
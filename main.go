@@ -3,29 +3,133 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
 
 	"onethinglab.com/imagedown/downloader"
 )
 
-func main() {
+// options are command line arguments.
+type options struct {
+	baseURL   string
+	outputDir string
+	insecure  bool
+	listOnly  bool
+	inputFile string
+	zipFile   string
+	version   bool
+}
+
+// parseArgs parse and validate command line arguments, without the program
+// name. Errors are printed along with the usage.
+func parseArgs(args []string) (options, error) {
 	var (
-		baseURL   = flag.String("--url", "https://onethinglab.com", "Specify URL to download images from.")
-		outputDir = flag.String("--dir", "/tmp/", "Specify directory where images will be stored.")
-		feedback  = make(chan downloader.DownloadEntry)
+		opts  options
+		flags = flag.NewFlagSet("imagedown", flag.ContinueOnError)
 	)
+	flags.StringVar(&opts.baseURL, "url", "https://onethinglab.com", "Specify URL to download images from.")
+	flags.StringVar(&opts.outputDir, "dir", "/tmp/", "Specify directory where images will be stored.")
+	flags.BoolVar(&opts.insecure, "insecure", false, "Skip verification of the server TLS certificate.")
+	flags.BoolVar(&opts.listOnly, "list", false, "List found images without downloading them.")
+	flags.StringVar(&opts.inputFile, "file", "", "Read the page from local HTML file, \"-\" for stdin. URL is used to resolve relative links.")
+	flags.StringVar(&opts.zipFile, "zip", "", "Store images in zip archive at the path instead of the directory.")
+	flags.BoolVar(&opts.version, "version", false, "Print the version and exit.")
+
+	if err := flags.Parse(args); err != nil {
+		return opts, err
+	}
+	if opts.version {
+		return opts, nil
+	}
+	if err := downloader.CheckPageURL(opts.baseURL); err != nil {
+		fmt.Fprintln(flags.Output(), err)
+		flags.Usage()
+		return opts, err
+	}
+	return opts, nil
+}
+
+// run download images as requested by `opts`, reporting progress to the log.
+func run(opts options) error {
+	if opts.version {
+		fmt.Println(downloader.VersionInfo())
+		return nil
+	}
+
+	log.Println("Downloading images from:", opts.baseURL, "to:", opts.outputDir)
 
-	log.Println("Downloading images from:", *baseURL, "to:", *outputDir)
+	config := downloader.DefaultConfig()
+	config.InsecureSkipVerify = opts.insecure
+	config.ListOnly = opts.listOnly
+	config.Logger = log.Default()
+	var archive *downloader.ZipSink
+	if len(opts.zipFile) > 0 {
+		file, err := os.Create(opts.zipFile)
+		if err != nil {
+			return fmt.Errorf("failed to create archive: %w", err)
+		}
+		defer file.Close()
+		archive = downloader.NewZipSink(file)
+		config.Sink = archive
+	}
 
-	go downloader.DownloadImages(*baseURL, *outputDir, feedback)
+	feedback := make(chan downloader.DownloadEntry)
+	if len(opts.inputFile) > 0 {
+		var input io.Reader = os.Stdin
+		if opts.inputFile != "-" {
+			file, err := os.Open(opts.inputFile)
+			if err != nil {
+				return fmt.Errorf("failed to open page: %w", err)
+			}
+			defer file.Close()
+			input = file
+		}
+		go downloader.DownloadImagesFromReaderWithConfig(input, opts.baseURL, opts.outputDir,
+			config, feedback)
+	} else {
+		go downloader.DownloadImagesWithConfig(opts.baseURL, opts.outputDir, config, feedback)
+	}
 
 	for entry := range feedback {
-		if entry.Error != nil {
-			log.Println("Error occurred while dowloading image: ", entry.Error)
+		if entry.Error != nil && len(entry.ElementType) == 0 {
+			log.Printf("Error occurred while dowloading %s: %v\n", entry.SourceURL, entry.Error)
+		} else if entry.Error != nil {
+			log.Printf("Error occurred while dowloading %s from %s: %v\n", entry.SourceURL,
+				entry.ElementType, entry.Error)
+		} else if opts.listOnly {
+			log.Printf("Found %s in %s\n", entry.SourceURL, entry.ElementType)
+		} else if entry.Skipped {
+			log.Printf("Skipping existing %s from %s\n", entry.Filename, entry.ElementType)
+		} else if entry.Filtered {
+			log.Printf("Skipping image from %s discarded by filters\n", entry.ElementType)
 		} else {
-			log.Printf("Downloading %s\n", entry.Filename)
+			log.Printf("Downloading %s from %s\n", entry.Filename, entry.ElementType)
+		}
+	}
+
+	if archive != nil {
+		// the archive is complete only when the sink is closed
+		if err := archive.Close(); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
 		}
 	}
 
 	log.Printf("Done.")
+	return nil
+}
+
+func main() {
+	opts, err := parseArgs(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		// the error and usage are already printed
+		os.Exit(2)
+	}
+
+	if err := run(opts); err != nil {
+		log.Fatalln(err)
+	}
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 1x1 transparent PNG.
+const pixelPNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+
+func TestRun(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(pixelPNG)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/pixel.png" {
+			w.Write(png)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><img src="/pixel.png"></body></html>`))
+	}))
+	defer server.Close()
+	dir := filepath.Join(t.TempDir(), "images")
+
+	for _, args := range [][]string{
+		{"-url", server.URL, "-dir", dir},
+		{"--url=" + server.URL, "--dir=" + dir},
+	} {
+		os.RemoveAll(dir)
+		opts, err := parseArgs(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.baseURL != server.URL || opts.outputDir != dir {
+			t.Errorf("%v: parsed %+v", args, opts)
+		}
+		if err := run(opts); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "pixel.png")); err != nil {
+			t.Errorf("%v: image is not downloaded: %v", args, err)
+		}
+	}
+}
+
+func TestParseArgsURL(t *testing.T) {
+	for _, url := range []string{"", "example.com", "ftp://example.com/"} {
+		if _, err := parseArgs([]string{"-url", url}); err == nil {
+			t.Errorf("expected error for URL %q", url)
+		}
+	}
+	if _, err := parseArgs([]string{"-version", "-url", ""}); err != nil {
+		t.Errorf("URL is not needed to print the version, got %v", err)
+	}
+}